@@ -0,0 +1,229 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/rickycorte/pantofola-rest/cascade"
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// Principal is the identity of an authenticated request
+type Principal struct {
+	Name   string // user name, token owner or api key owner
+	Scheme string // authenticator that accepted the request es: Basic, Bearer, ApiKey
+	Scopes []string
+	Claims map[string]interface{} // extra data about the principal
+}
+
+// Authenticator checks the credentials of a request
+type Authenticator interface {
+	// Authenticate returns the principal of a request, ErrNoCredentials if the request has no credentials
+	// for this authenticator and ErrInvalidCredentials (or any other error) if they are not accepted
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge is the WWW-Authenticate value sent on 401, err is the authentication error
+	Challenge(err error) string
+}
+
+// Guard is an authentication middleware that can be used on single handlers, groups (sub routers) and globally
+// the authenticators are tried in order and the first that accepts the request wins
+type Guard struct {
+	authenticators []Authenticator
+	optional       bool
+	routeScopes    bool
+	onError        router.ErrorHandler
+}
+
+type guardRouter struct {
+	inner http.Handler
+	guard *Guard
+}
+
+type principalKey struct{}
+
+// ErrNoCredentials is returned by authenticators when the request has no credentials for them
+var ErrNoCredentials = &router.HTTPError{Status: http.StatusUnauthorized, Message: "Authentication required"}
+
+// ErrInvalidCredentials is returned by authenticators when the credentials are not accepted
+var ErrInvalidCredentials = &router.HTTPError{Status: http.StatusUnauthorized, Message: "Invalid credentials"}
+
+//*********************************************************************************************************************
+// Principal
+
+// HasScope checks if the principal has a scope
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPrincipal returns a copy of the request that carries a principal
+func WithPrincipal(req *http.Request, p *Principal) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), principalKey{}, p))
+}
+
+// GetPrincipal returns the principal of an authenticated request, nil if the request is anonymous
+func GetPrincipal(req *http.Request) *Principal {
+	p, _ := req.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+//*********************************************************************************************************************
+// Guard
+
+// New creates a guard that accepts requests authenticated by any of the authenticators
+func New(authenticators ...Authenticator) *Guard {
+	g := &Guard{authenticators: authenticators}
+	g.onError = g.writeError
+	return g
+}
+
+// Optional lets requests without credentials through as anonymous, invalid credentials are still rejected
+func (g *Guard) Optional() *Guard {
+	g.optional = true
+	return g
+}
+
+// RequireRouteScopes rejects with 403 the principals that don't have all the scopes in the metadata of the route
+// routes are known only by single handlers, Global and Group can't check them
+func (g *Guard) RequireRouteScopes() *Guard {
+	g.routeScopes = true
+	return g
+}
+
+// OnError sets the function used to write authentication errors
+// challenges are already in the responce headers when it is called
+func (g *Guard) OnError(handler router.ErrorHandler) *Guard {
+	g.onError = handler
+	return g
+}
+
+// default error writer
+func (g *Guard) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	router.RenderProblem(w, r, router.ProblemFromError(err, r))
+}
+
+// authenticate a request, returns the request with the principal or the error to send
+func (g *Guard) authenticate(r *http.Request) (*http.Request, error) {
+	var firstErr error
+	for _, a := range g.authenticators {
+		p, err := a.Authenticate(r)
+		if err == nil && p != nil {
+			return WithPrincipal(r, p), nil
+		}
+		if err == nil {
+			err = ErrInvalidCredentials
+		}
+		if firstErr == nil && !errors.Is(err, ErrNoCredentials) {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return r, firstErr
+	}
+	if g.optional {
+		return r, nil
+	}
+	return r, ErrNoCredentials
+}
+
+// check that the principal has all the scopes required by a route
+func checkScopes(r *http.Request, route *router.Route) error {
+	if route == nil {
+		return nil
+	}
+	p := GetPrincipal(r)
+	if p == nil && len(route.Metadata.Scopes) > 0 {
+		return ErrNoCredentials
+	}
+	for _, scope := range route.Metadata.Scopes {
+		if !p.HasScope(scope) {
+			return &router.HTTPError{Status: http.StatusForbidden, Message: "Missing scope " + scope}
+		}
+	}
+	return nil
+}
+
+// reject a request, 401 responces get a challenge for every authenticator
+func (g *Guard) reject(w http.ResponseWriter, r *http.Request, err error) {
+	if router.ErrorStatus(err) == http.StatusUnauthorized {
+		for _, a := range g.authenticators {
+			if challenge := a.Challenge(err); challenge != "" {
+				w.Header().Add("WWW-Authenticate", challenge)
+			}
+		}
+	}
+	g.onError(w, r, err)
+}
+
+// Handler authenticates the requests of a single handler
+func (g *Guard) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		r, err := g.authenticate(r)
+		if err == nil && g.routeScopes {
+			err = checkScopes(r, p.Route())
+		}
+		if err != nil {
+			g.reject(w, r, err)
+			return
+		}
+		handler(w, r, p)
+	}
+}
+
+// Global returns a router that authenticates all the requests
+func (g *Guard) Global(inner http.Handler) http.Handler {
+	return &guardRouter{inner: inner, guard: g}
+}
+
+func (gr *guardRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, err := gr.guard.authenticate(r)
+	if err != nil {
+		gr.guard.reject(w, r, err)
+		return
+	}
+	gr.inner.ServeHTTP(w, r)
+}
+
+// Group protects a sub router that is mounted in a cascade router
+func (g *Guard) Group(inner cascade.Handler) cascade.Handler {
+	return &guardRouter{inner: inner, guard: g}
+}
+
+// UsePrefix forwards the prefix to the inner router so a guarded router can be used in a cascade
+func (gr *guardRouter) UsePrefix(prefix string) {
+	if ch, ok := gr.inner.(cascade.Handler); ok {
+		ch.UsePrefix(prefix)
+	}
+}
+
+// SetLogger forwards the logger of a cascade to the inner router
+func (gr *guardRouter) SetLogger(logger router.Logger) {
+	if ls, ok := gr.inner.(interface{ SetLogger(router.Logger) }); ok {
+		ls.SetLogger(logger)
+	}
+}
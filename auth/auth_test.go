@@ -0,0 +1,135 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/cascade"
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func whoAmI(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+	if p := GetPrincipal(r); p != nil {
+		w.Write([]byte(p.Scheme + ":" + p.Name))
+		return
+	}
+	w.Write([]byte("anonymous"))
+}
+
+func runRequest(handler http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+func TestAuthenticators(t *testing.T) {
+	guard := New(
+		Basic("api", BasicUsers(map[string]string{"admin": "secret"})),
+		Bearer("api", StaticTokens(map[string]string{"tok-1": "service"})),
+		APIKeyHeader("X-API-Key", StaticTokens(map[string]string{"key-1": "mobile"})),
+		APIKeyQuery("api_key", func(_ *http.Request, key string) (*Principal, error) {
+			if key == "broken" {
+				return nil, errors.New("database is down")
+			}
+			return nil, nil
+		}),
+	)
+
+	rt := router.MakeRouter()
+	rt.GET("/me", guard.Handler(whoAmI))
+	rt.GET("/public", New(Bearer("api", StaticTokens(nil))).Optional().Handler(whoAmI))
+
+	res := runRequest(rt, "/me", nil)
+	challenges := res.Header()["Www-Authenticate"]
+	if res.Code != 401 || len(challenges) != 4 || challenges[0] != `Basic realm="api", charset="UTF-8"` ||
+		challenges[1] != `Bearer realm="api"` {
+		t.Errorf("Unexpected missing credentials responce: %d %v", res.Code, challenges)
+	}
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.SetBasicAuth("admin", "secret")
+	res = httptest.NewRecorder()
+	rt.ServeHTTP(res, req)
+	if res.Body.String() != "Basic:admin" {
+		t.Errorf("Basic auth failed: %d %s", res.Code, res.Body.String())
+	}
+
+	req.SetBasicAuth("admin", "wrong")
+	res = httptest.NewRecorder()
+	rt.ServeHTTP(res, req)
+	if res.Code != 401 || !strings.Contains(res.Body.String(), "Invalid credentials") {
+		t.Errorf("Wrong password accepted: %d", res.Code)
+	}
+
+	if res = runRequest(rt, "/me", map[string]string{"Authorization": "bearer tok-1"}); res.Body.String() != "Bearer:service" {
+		t.Errorf("Bearer auth failed: %d %s", res.Code, res.Body.String())
+	}
+	res = runRequest(rt, "/me", map[string]string{"Authorization": "Bearer nope"})
+	if res.Code != 401 || res.Header()["Www-Authenticate"][1] != `Bearer realm="api", error="invalid_token"` {
+		t.Errorf("Invalid token challenge missing: %v", res.Header())
+	}
+
+	if res = runRequest(rt, "/me", map[string]string{"X-API-Key": "key-1"}); res.Body.String() != "ApiKey:mobile" {
+		t.Errorf("Api key auth failed: %d %s", res.Code, res.Body.String())
+	}
+	if res = runRequest(rt, "/me?api_key=broken", nil); res.Code != 500 || res.Header().Get("WWW-Authenticate") != "" {
+		t.Errorf("Verifier errors should not be 401: %d", res.Code)
+	}
+
+	if res = runRequest(rt, "/public", nil); res.Body.String() != "anonymous" {
+		t.Errorf("Optional guard rejected anonymous request: %d", res.Code)
+	}
+	if res = runRequest(rt, "/public", map[string]string{"Authorization": "Bearer nope"}); res.Code != 401 {
+		t.Errorf("Optional guard accepted invalid token: %d", res.Code)
+	}
+}
+
+func TestAuthGroups(t *testing.T) {
+	guard := New(Bearer("admin", StaticTokens(map[string]string{"root": "root"})))
+
+	admin := router.MakeRouter()
+	admin.GET("/stats", whoAmI)
+	public := router.MakeRouter()
+	public.GET("/stats", whoAmI)
+
+	cr := cascade.MakeCascade()
+	cr.Set("", public)
+	cr.Set("/admin", guard.Group(admin))
+
+	if res := runRequest(cr, "/stats", nil); res.Body.String() != "anonymous" {
+		t.Errorf("Public route should not be guarded: %d", res.Code)
+	}
+	if res := runRequest(cr, "/admin/stats", nil); res.Code != 401 {
+		t.Errorf("Group route not guarded: %d", res.Code)
+	}
+	if res := runRequest(cr, "/admin/stats", map[string]string{"Authorization": "Bearer root"}); res.Body.String() != "Bearer:root" {
+		t.Errorf("Group route rejected valid token: %d %s", res.Code, res.Body.String())
+	}
+
+	if res := runRequest(guard.Global(public), "/stats", nil); res.Code != 401 {
+		t.Errorf("Global guard not applied: %d", res.Code)
+	}
+}
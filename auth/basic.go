@@ -0,0 +1,79 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+)
+
+// BasicVerifier checks a user and password pair, it returns nil if they are not valid
+type BasicVerifier func(r *http.Request, user, password string) (*Principal, error)
+
+type basicAuth struct {
+	realm  string
+	verify BasicVerifier
+}
+
+//*********************************************************************************************************************
+
+// SecureCompare compares two secrets in constant time, the time does not depend on the length of the secrets
+func SecureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// BasicUsers creates a verifier for a fixed map of users and passwords
+func BasicUsers(users map[string]string) BasicVerifier {
+	return func(_ *http.Request, user, password string) (*Principal, error) {
+		expected, ok := users[user]
+		// compare anyway so unknown users take the same time
+		if !SecureCompare(password, expected) || !ok {
+			return nil, nil
+		}
+		return &Principal{Name: user}, nil
+	}
+}
+
+// Basic creates an authenticator for the Basic scheme (RFC 7617)
+func Basic(realm string, verify BasicVerifier) Authenticator {
+	return &basicAuth{realm: realm, verify: verify}
+}
+
+func (ba *basicAuth) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	p, err := ba.verify(r, user, password)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrInvalidCredentials
+	}
+	p.Scheme = "Basic"
+	return p, nil
+}
+
+func (ba *basicAuth) Challenge(error) string {
+	return "Basic realm=" + strconv.Quote(ba.realm) + `, charset="UTF-8"`
+}
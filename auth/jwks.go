@@ -0,0 +1,235 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+)
+
+// Key is a verification key of a key set
+type Key struct {
+	ID     string
+	Secret []byte           // HMAC secret for HS256/384/512
+	RSA    *rsa.PublicKey   // RS256
+	ECDSA  *ecdsa.PublicKey // ES256 (P-256)
+}
+
+// KeySet is a set of keys used to verify tokens, it can be changed while in use
+type KeySet struct {
+	keys  []*Key
+	mutex sync.RWMutex
+}
+
+// json web key as in RFC 7517, only the fields used for verification
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+//*********************************************************************************************************************
+// KeySet
+
+// NewKeySet creates an empty key set
+func NewKeySet() *KeySet {
+	return &KeySet{}
+}
+
+// Add adds a key to the set, a key with the same id is replaced
+func (ks *KeySet) Add(key *Key) *KeySet {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	if key.ID != "" {
+		for i, k := range ks.keys {
+			if k.ID == key.ID {
+				ks.keys[i] = key
+				return ks
+			}
+		}
+	}
+	ks.keys = append(ks.keys, key)
+	return ks
+}
+
+// AddHMAC adds a shared secret
+func (ks *KeySet) AddHMAC(id string, secret []byte) *KeySet {
+	return ks.Add(&Key{ID: id, Secret: secret})
+}
+
+// AddRSA adds a rsa public key
+func (ks *KeySet) AddRSA(id string, key *rsa.PublicKey) *KeySet {
+	return ks.Add(&Key{ID: id, RSA: key})
+}
+
+// AddECDSA adds an ecdsa public key
+func (ks *KeySet) AddECDSA(id string, key *ecdsa.PublicKey) *KeySet {
+	return ks.Add(&Key{ID: id, ECDSA: key})
+}
+
+// Replace swaps all the keys with the ones of another set, use it to reload a JWKS
+func (ks *KeySet) Replace(other *KeySet) {
+	other.mutex.RLock()
+	keys := append([]*Key(nil), other.keys...)
+	other.mutex.RUnlock()
+
+	ks.mutex.Lock()
+	ks.keys = keys
+	ks.mutex.Unlock()
+}
+
+// find the keys that can verify a token, an id selects a single key
+func (ks *KeySet) candidates(id string) []*Key {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	if id != "" {
+		for _, k := range ks.keys {
+			if k.ID == id {
+				return []*Key{k}
+			}
+		}
+		return nil
+	}
+	return append([]*Key(nil), ks.keys...)
+}
+
+//*********************************************************************************************************************
+// JWKS
+
+// decode a base64url big endian number
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid number")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// convert a json web key, keys that are not for signatures or not supported are skipped (nil)
+func (jwk *jsonWebKey) toKey() (*Key, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: modulus: %v", jwk.Kid, err)
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("key %q: invalid exponent", jwk.Kid)
+		}
+		return &Key{ID: jwk.Kid, RSA: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: x: %v", jwk.Kid, err)
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: y: %v", jwk.Kid, err)
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("key %q: point is not on the curve", jwk.Kid)
+		}
+		return &Key{ID: jwk.Kid, ECDSA: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("key %q: invalid secret", jwk.Kid)
+		}
+		return &Key{ID: jwk.Kid, Secret: secret}, nil
+	}
+
+	return nil, nil
+}
+
+// ParseJWKS creates a key set from a JWKS document (RFC 7517), unsupported keys are ignored
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %v", err)
+	}
+
+	ks := NewKeySet()
+	for i := range doc.Keys {
+		key, err := doc.Keys[i].toKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks: %v", err)
+		}
+		if key != nil {
+			ks.Add(key)
+		}
+	}
+	return ks, nil
+}
+
+// LoadJWKS reads a key set from a JWKS file
+func LoadJWKS(path string) (*KeySet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// FetchJWKS downloads a key set from an url, if client is nil http.DefaultClient is used
+func FetchJWKS(client *http.Client, url string) (*KeySet, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks request failed with status %d", res.StatusCode)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
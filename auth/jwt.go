@@ -0,0 +1,308 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// JWTConfig describes the accepted tokens
+type JWTConfig struct {
+	Keys *KeySet
+	// Algorithms accepted, default is HS256, HS384, HS512, RS256 and ES256
+	// every algorithm works only with keys of its type
+	Algorithms []string
+	// Issuer and Audience are checked if not empty
+	Issuer   string
+	Audience string
+	// ClockSkew is the tolerance used for exp and nbf
+	ClockSkew time.Duration
+	// AllowNoExpiry accepts tokens without an exp claim
+	AllowNoExpiry bool
+	// Realm used in the WWW-Authenticate challenge
+	Realm string
+	// Now returns the current time, default is time.Now
+	Now func() time.Time
+}
+
+// JWTVerifier checks the signature and the claims of json web tokens
+type JWTVerifier struct {
+	keys          *KeySet
+	algorithms    map[string]bool
+	issuer        string
+	audience      string
+	skew          time.Duration
+	allowNoExpiry bool
+	now           func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// ErrInvalidToken is the cause of the errors of tokens that are not accepted
+var ErrInvalidToken = errors.New("invalid token")
+
+var defaultJWTAlgorithms = []string{"HS256", "HS384", "HS512", "RS256", "ES256"}
+
+//*********************************************************************************************************************
+
+// NewJWTVerifier creates a token verifier, it panics if there are no keys
+func NewJWTVerifier(config JWTConfig) *JWTVerifier {
+	if config.Keys == nil {
+		panic("JWT verification requires a key set")
+	}
+
+	v := &JWTVerifier{
+		keys:          config.Keys,
+		algorithms:    make(map[string]bool),
+		issuer:        config.Issuer,
+		audience:      config.Audience,
+		skew:          config.ClockSkew,
+		allowNoExpiry: config.AllowNoExpiry,
+		now:           config.Now,
+	}
+	algorithms := config.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = defaultJWTAlgorithms
+	}
+	for _, alg := range algorithms {
+		v.algorithms[alg] = true
+	}
+	if v.now == nil {
+		v.now = time.Now
+	}
+	return v
+}
+
+// JWT creates an authenticator for json web tokens sent as bearer tokens
+// the principal name is the sub claim, scopes come from scope (space separated) or scp claims
+func JWT(config JWTConfig) Authenticator {
+	return Bearer(config.Realm, NewJWTVerifier(config).Verify)
+}
+
+// create the error of a rejected token
+func tokenError(reason string) error {
+	return &router.HTTPError{Status: http.StatusUnauthorized, Message: "Invalid token", Err: fmt.Errorf("%w: %s", ErrInvalidToken, reason)}
+}
+
+// Verify implements TokenVerifier
+func (v *JWTVerifier) Verify(_ *http.Request, token string) (*Principal, error) {
+	claims, err := v.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Claims: claims}
+	p.Name, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		p.Scopes = strings.Fields(scope)
+	}
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if str, ok := s.(string); ok {
+				p.Scopes = append(p.Scopes, str)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Parse checks a token and returns its claims, numbers are json.Number
+func (v *JWTVerifier) Parse(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, tokenError("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, tokenError("malformed header")
+	}
+	if !v.algorithms[header.Alg] {
+		return nil, tokenError("algorithm not accepted")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, tokenError("malformed signature")
+	}
+	signed := []byte(token[:len(parts[0])+1+len(parts[1])])
+
+	verified := false
+	for _, key := range v.keys.candidates(header.Kid) {
+		if verifySignature(header.Alg, key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, tokenError("signature not valid")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, tokenError("malformed claims")
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// check the registered claims
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, hasExp, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !hasExp && !v.allowNoExpiry {
+		return tokenError("missing exp")
+	}
+	if hasExp && !now.Before(exp.Add(v.skew)) {
+		return tokenError("token expired")
+	}
+
+	nbf, hasNbf, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if hasNbf && now.Add(v.skew).Before(nbf) {
+		return tokenError("token not valid yet")
+	}
+
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return tokenError("wrong issuer")
+		}
+	}
+
+	if v.audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == v.audience
+		case []interface{}:
+			for _, a := range aud {
+				if s, ok := a.(string); ok && s == v.audience {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return tokenError("wrong audience")
+		}
+	}
+
+	return nil
+}
+
+//*********************************************************************************************************************
+
+// decode a base64url json segment of a token
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(out)
+}
+
+// read a time claim in seconds since epoch
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, tokenError("malformed " + name)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, tokenError("malformed " + name)
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+// verify a signature with a key, keys of a different type than the algorithm are never used
+func verifySignature(alg string, key *Key, signed, signature []byte) bool {
+	var hashFunc func() hash.Hash
+	var cryptoHash crypto.Hash
+	switch alg {
+	case "HS256", "RS256", "ES256":
+		hashFunc, cryptoHash = sha256.New, crypto.SHA256
+	case "HS384":
+		hashFunc, cryptoHash = sha512.New384, crypto.SHA384
+	case "HS512":
+		hashFunc, cryptoHash = sha512.New, crypto.SHA512
+	default:
+		return false
+	}
+
+	switch alg[:2] {
+	case "HS":
+		if len(key.Secret) == 0 {
+			return false
+		}
+		mac := hmac.New(hashFunc, key.Secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+
+	case "RS":
+		if key.RSA == nil {
+			return false
+		}
+		h := hashFunc()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(key.RSA, cryptoHash, h.Sum(nil), signature) == nil
+
+	case "ES":
+		if key.ECDSA == nil || key.ECDSA.Curve != elliptic.P256() || len(signature) != 64 {
+			return false
+		}
+		h := hashFunc()
+		h.Write(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key.ECDSA, h.Sum(nil), r, s)
+	}
+	return false
+}
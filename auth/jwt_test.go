@@ -0,0 +1,201 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+var testNow = time.Unix(1600000000, 0)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign a token with HS256, RS256 or ES256
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestJWTClaims(t *testing.T) {
+	secret := []byte("very secret")
+	verifier := NewJWTVerifier(JWTConfig{
+		Keys:      NewKeySet().AddHMAC("hs", secret),
+		Issuer:    "https://id.example.com",
+		Audience:  "api",
+		ClockSkew: 30 * time.Second,
+		Now:       func() time.Time { return testNow },
+	})
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub": "user-1", "iss": "https://id.example.com", "aud": []string{"other", "api"},
+			"exp": testNow.Unix() + 60, "nbf": testNow.Unix(), "scope": "read write",
+		}
+	}
+
+	p, err := verifier.Verify(nil, signToken(t, "HS256", "hs", secret, valid()))
+	if err != nil || p.Name != "user-1" || !p.HasScope("write") || p.Claims["iss"] != "https://id.example.com" {
+		t.Fatalf("Valid token rejected: %v %+v", err, p)
+	}
+
+	cases := map[string]func(map[string]interface{}){
+		"expired":      func(c map[string]interface{}) { c["exp"] = testNow.Unix() - 31 },
+		"not yet":      func(c map[string]interface{}) { c["nbf"] = testNow.Unix() + 31 },
+		"issuer":       func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"audience":     func(c map[string]interface{}) { c["aud"] = "other" },
+		"no expiry":    func(c map[string]interface{}) { delete(c, "exp") },
+		"string dates": func(c map[string]interface{}) { c["exp"] = "tomorrow" },
+	}
+	for name, change := range cases {
+		claims := valid()
+		change(claims)
+		if _, err := verifier.Verify(nil, signToken(t, "HS256", "hs", secret, claims)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Token with wrong %s accepted: %v", name, err)
+		}
+	}
+
+	skewed := valid()
+	skewed["exp"] = testNow.Unix() - 20
+	if _, err := verifier.Verify(nil, signToken(t, "HS256", "hs", secret, skewed)); err != nil {
+		t.Errorf("Clock skew not applied: %v", err)
+	}
+
+	if _, err := verifier.Verify(nil, signToken(t, "HS256", "hs", []byte("wrong"), valid())); err == nil {
+		t.Errorf("Token with wrong secret accepted")
+	}
+	if _, err := verifier.Verify(nil, signToken(t, "none", "hs", secret, valid())); err == nil {
+		t.Errorf("Token with alg none accepted")
+	}
+}
+
+func TestJWTKeySets(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+	}})
+
+	dir, _ := ioutil.TempDir("", "jwks")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(path, jwks, 0644)
+	fileKeys, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatalf("Unable to load jwks: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(jwks)
+	}))
+	defer server.Close()
+	remoteKeys, err := FetchJWKS(server.Client(), server.URL)
+	if err != nil {
+		t.Fatalf("Unable to fetch jwks: %v", err)
+	}
+
+	claims := map[string]interface{}{"sub": "svc", "exp": testNow.Unix() + 60}
+	for _, keys := range []*KeySet{fileKeys, remoteKeys} {
+		if len(keys.candidates("")) != 2 {
+			t.Errorf("Encryption keys should be skipped")
+		}
+		verifier := NewJWTVerifier(JWTConfig{Keys: keys, Now: func() time.Time { return testNow }})
+
+		if _, err := verifier.Verify(nil, signToken(t, "RS256", "rsa", rsaKey, claims)); err != nil {
+			t.Errorf("RS256 token rejected: %v", err)
+		}
+		if _, err := verifier.Verify(nil, signToken(t, "ES256", "ec", ecKey, claims)); err != nil {
+			t.Errorf("ES256 token rejected: %v", err)
+		}
+		// without kid every key of the right type is tried
+		if _, err := verifier.Verify(nil, signToken(t, "ES256", "", ecKey, claims)); err != nil {
+			t.Errorf("ES256 token without kid rejected: %v", err)
+		}
+		// the public key can't be used as hmac secret
+		pub := rsaKey.N.Bytes()
+		if _, err := verifier.Verify(nil, signToken(t, "HS256", "rsa", pub, claims)); err == nil {
+			t.Errorf("Algorithm confusion accepted")
+		}
+	}
+}
+
+func TestJWTRouteScopes(t *testing.T) {
+	secret := []byte("secret")
+	guard := New(JWT(JWTConfig{Keys: NewKeySet().AddHMAC("", secret), Realm: "api"})).RequireRouteScopes()
+
+	rt := router.MakeRouter()
+	rt.GET("/reports", guard.Handler(whoAmI)).WithScopes("reports:read")
+
+	exp := time.Now().Add(time.Minute).Unix()
+	reader := signToken(t, "HS256", "", secret, map[string]interface{}{"sub": "a", "exp": exp, "scp": []string{"reports:read"}})
+	other := signToken(t, "HS256", "", secret, map[string]interface{}{"sub": "b", "exp": exp, "scope": "users:read"})
+
+	if res := runRequest(rt, "/reports", map[string]string{"Authorization": "Bearer " + reader}); res.Body.String() != "Bearer:a" {
+		t.Errorf("Token with scope rejected: %d %s", res.Code, res.Body.String())
+	}
+	if res := runRequest(rt, "/reports", map[string]string{"Authorization": "Bearer " + other}); res.Code != 403 {
+		t.Errorf("Token without scope accepted: %d", res.Code)
+	}
+	res := runRequest(rt, "/reports", map[string]string{"Authorization": "Bearer " + other + "x"})
+	if res.Code != 401 || res.Header().Get("WWW-Authenticate") != `Bearer realm="api", error="invalid_token"` {
+		t.Errorf("Unexpected invalid token responce: %d %v", res.Code, res.Header())
+	}
+}
@@ -0,0 +1,138 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TokenVerifier checks a bearer token or an api key, it returns nil if the token is not valid
+type TokenVerifier func(r *http.Request, token string) (*Principal, error)
+
+type bearerAuth struct {
+	realm  string
+	verify TokenVerifier
+}
+
+type apiKeyAuth struct {
+	header string
+	query  string
+	verify TokenVerifier
+}
+
+//*********************************************************************************************************************
+
+// StaticTokens creates a verifier for a fixed map of tokens and principal names
+// tokens are looked up by hash so the lookup time does not depend on how much of a token matches
+func StaticTokens(tokens map[string]string) TokenVerifier {
+	hashes := make(map[[sha256.Size]byte]string, len(tokens))
+	for token, name := range tokens {
+		hashes[sha256.Sum256([]byte(token))] = name
+	}
+
+	return func(_ *http.Request, token string) (*Principal, error) {
+		name, ok := hashes[sha256.Sum256([]byte(token))]
+		if !ok {
+			return nil, nil
+		}
+		return &Principal{Name: name}, nil
+	}
+}
+
+// check the result of a verifier
+func verifyToken(verify TokenVerifier, r *http.Request, token, scheme string) (*Principal, error) {
+	p, err := verify(r, token)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrInvalidCredentials
+	}
+	p.Scheme = scheme
+	return p, nil
+}
+
+//*********************************************************************************************************************
+// Bearer
+
+// Bearer creates an authenticator for bearer tokens in the Authorization header (RFC 6750)
+func Bearer(realm string, verify TokenVerifier) Authenticator {
+	return &bearerAuth{realm: realm, verify: verify}
+}
+
+// BearerToken returns the bearer token of a request or an empty string
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+func (ba *bearerAuth) Authenticate(r *http.Request) (*Principal, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	return verifyToken(ba.verify, r, token, "Bearer")
+}
+
+func (ba *bearerAuth) Challenge(err error) string {
+	challenge := "Bearer realm=" + strconv.Quote(ba.realm)
+	if err != nil && !errors.Is(err, ErrNoCredentials) {
+		challenge += `, error="invalid_token"`
+	}
+	return challenge
+}
+
+//*********************************************************************************************************************
+// API keys
+
+// APIKeyHeader creates an authenticator for api keys sent in a header (es: X-API-Key)
+func APIKeyHeader(header string, verify TokenVerifier) Authenticator {
+	return &apiKeyAuth{header: header, verify: verify}
+}
+
+// APIKeyQuery creates an authenticator for api keys sent as query parameter (es: ?api_key=)
+func APIKeyQuery(param string, verify TokenVerifier) Authenticator {
+	return &apiKeyAuth{query: param, verify: verify}
+}
+
+func (ka *apiKeyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	var key string
+	if ka.header != "" {
+		key = r.Header.Get(ka.header)
+	} else {
+		key = r.URL.Query().Get(ka.query)
+	}
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	return verifyToken(ka.verify, r, key, "ApiKey")
+}
+
+// Challenge uses a non standard ApiKey scheme that tells the client where the key is expected
+func (ka *apiKeyAuth) Challenge(error) string {
+	if ka.header != "" {
+		return "ApiKey header=" + strconv.Quote(ka.header)
+	}
+	return "ApiKey query=" + strconv.Quote(ka.query)
+}
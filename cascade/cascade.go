@@ -32,6 +32,11 @@ type Handler interface {
 	UsePrefix(string)
 }
 
+// loggerSetter is implemented by handlers that accept a logger, es: router.Router
+type loggerSetter interface {
+	SetLogger(router.Logger)
+}
+
 // CascadeRouter is a way to nest routers with prefixies
 // the main use of there is in large application that should have different behaviours
 // for example we want to run an api endpoint on "/api" prefix and also serve static files on "/"
@@ -42,13 +47,15 @@ type CascadeRouter struct {
 	mainRouter Handler // main router has no prefix and will be used if no match is found in sub routers
 	subRouters map[string]Handler
 	prefix     string
+	logger     router.Logger
+	hasLogger  bool // true if the logger was set by the user and should be given to childs
 }
 
 //*********************************************************************************************************************
 
 // MakeCascade cretes an empty cascade router
 func MakeCascade() *CascadeRouter {
-	return &CascadeRouter{}
+	return &CascadeRouter{logger: router.DefaultLogger()}
 }
 
 // Set set the router handler for a prefix
@@ -61,6 +68,7 @@ func (cr *CascadeRouter) Set(prefix string, rout Handler) {
 
 	if prefix == "" {
 		cr.mainRouter = rout
+		cr.propagateLogger(rout)
 		return
 	}
 
@@ -75,9 +83,11 @@ func (cr *CascadeRouter) Set(prefix string, rout Handler) {
 	rout.UsePrefix(cr.prefix + prefix)
 
 	cr.subRouters[prefix] = rout
+	cr.propagateLogger(rout)
 
 	if cr.mainRouter == nil {
 		cr.mainRouter = router.MakeRouter()
+		cr.propagateLogger(cr.mainRouter)
 	}
 }
 
@@ -105,6 +115,13 @@ func (cr *CascadeRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if r == nil {
+		cr.getLogger().Log(router.LevelWarn, "No router set in cascade", "path", req.URL.Path)
+		http.NotFound(w, req)
+		return
+	}
+
+	cr.getLogger().Log(router.LevelDebug, "Cascade routing", "prefix", cr.prefix+prefix, "path", req.URL.Path)
 	r.ServeHTTP(w, req)
 }
 
@@ -116,3 +133,32 @@ func (cr *CascadeRouter) UsePrefix(prefix string) {
 		v.UsePrefix(cr.prefix + k)
 	}
 }
+
+// SetLogger sets the logger of the cascade and of all the routers set until now (and in the future)
+// that accept a logger
+func (cr *CascadeRouter) SetLogger(logger router.Logger) {
+	cr.logger = logger
+	cr.hasLogger = true
+	cr.propagateLogger(cr.mainRouter)
+	for _, v := range cr.subRouters {
+		cr.propagateLogger(v)
+	}
+}
+
+// logger of the cascade, a zero value cascade uses the default logger
+func (cr *CascadeRouter) getLogger() router.Logger {
+	if cr.logger == nil {
+		return router.DefaultLogger()
+	}
+	return cr.logger
+}
+
+// set the cascade logger to a child if a custom logger was set
+func (cr *CascadeRouter) propagateLogger(child Handler) {
+	if !cr.hasLogger {
+		return
+	}
+	if ls, ok := child.(loggerSetter); ok {
+		ls.SetLogger(cr.logger)
+	}
+}
@@ -86,6 +86,17 @@ func TestCascadeSearch(t *testing.T) {
 	RunRequest(cascade, "GET", "/api/random", 404, "No api", t)
 }
 
+func TestZeroValueCascade(t *testing.T) {
+	apiRouter := router.MakeRouter()
+	apiRouter.GET("/a", apiA)
+
+	cascade := &CascadeRouter{}
+	RunRequest(cascade, "GET", "/api/a", 404, "404 page not found\n", t)
+
+	cascade.Set("/api", apiRouter)
+	RunRequest(cascade, "GET", "/api/a", 200, "A /api/a", t)
+}
+
 func TestNestedCascade(t *testing.T) {
 
 	apiRouter := router.MakeRouter()
@@ -132,3 +143,27 @@ func TestPanicOnWrongPrefix(t *testing.T) {
 	cascade.Set("/api/aldj", router.MakeRouter())
 
 }
+
+type countLogger struct {
+	count int
+}
+
+func (cl *countLogger) Log(router.LogLevel, string, ...interface{}) {
+	cl.count++
+}
+
+func TestCascadeLogger(t *testing.T) {
+	apiRouter := router.MakeRouter()
+	apiRouter.GET("/a", apiA)
+
+	logger := &countLogger{}
+	cascade := MakeCascade()
+	cascade.SetLogger(logger)
+	cascade.Set("/api", apiRouter)
+
+	// cascade dispatch and method not allowed in the sub router
+	RunRequest(cascade, "PUT", "/api/a", 405, "Method Not Allowed", t)
+	if logger.count != 2 {
+		t.Errorf("Expected 2 log messages, got: %v", logger.count)
+	}
+}
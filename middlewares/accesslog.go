@@ -0,0 +1,221 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// AccessLogFormat is the format of the lines written by an access logger
+type AccessLogFormat int
+
+// supported access log formats
+const (
+	CommonLogFormat   AccessLogFormat = iota // Apache common log format
+	CombinedLogFormat                        // Apache combined log format (common + referer and user agent)
+	JSONLogFormat                            // one json object per line
+	TemplateLogFormat                        // custom text/template executed on an AccessLogEntry
+)
+
+// AccessLogConfig describes how access logs are written
+type AccessLogConfig struct {
+	Output   io.Writer // default is os.Stdout
+	Format   AccessLogFormat
+	Template string // used only by TemplateLogFormat, a new line is added after every entry
+}
+
+// AccessLogEntry contains the data of a logged request
+type AccessLogEntry struct {
+	Time       time.Time
+	RemoteAddr string // client ip, resolved by a ProxyResolver if one is used
+	User       string // basic auth user if any
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	Referer    string
+	UserAgent  string
+	Route      string // pattern of the matched route, empty if no route matched
+	RequestID  string
+}
+
+// AccessLogger writes a line for every completed request in the configured format
+type AccessLogger struct {
+	out      io.Writer
+	format   AccessLogFormat
+	template *template.Template
+	mutex    sync.Mutex
+}
+
+// json line of an entry
+type jsonAccessLog struct {
+	Time       string  `json:"time"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Route      string  `json:"route,omitempty"`
+	RequestID  string  `json:"request_id,omitempty"`
+}
+
+type accessLogRouter struct {
+	inner  http.Handler
+	logger *AccessLogger
+}
+
+//*********************************************************************************************************************
+
+// NewAccessLogger creates an access logger, it panics if the template is not valid
+func NewAccessLogger(config AccessLogConfig) *AccessLogger {
+	al := &AccessLogger{out: config.Output, format: config.Format}
+	if al.out == nil {
+		al.out = os.Stdout
+	}
+	if config.Format == TemplateLogFormat {
+		al.template = template.Must(template.New("access").Parse(config.Template))
+	}
+	return al
+}
+
+// value or "-" if empty as used by apache formats
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// build the entry of a completed request
+func makeAccessLogEntry(r *http.Request, start time.Time, writer *router.ResponseWriter, route *router.Route) *AccessLogEntry {
+	entry := &AccessLogEntry{
+		Time:       start,
+		RemoteAddr: ClientIP(r),
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		Status:     writer.Status(),
+		Bytes:      writer.BytesWritten(),
+		Duration:   time.Since(start),
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		RequestID:  requestIDOf(writer, r),
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		entry.User = user
+	}
+	if entry.URI == "" {
+		entry.URI = r.URL.RequestURI()
+	}
+	if route != nil {
+		entry.Route = route.Pattern()
+	}
+	return entry
+}
+
+// format an entry as a single line
+func (al *AccessLogger) formatEntry(e *AccessLogEntry) []byte {
+	var buf bytes.Buffer
+
+	switch al.format {
+	case JSONLogFormat:
+		line, _ := json.Marshal(&jsonAccessLog{
+			Time:       e.Time.Format(time.RFC3339Nano),
+			RemoteAddr: e.RemoteAddr,
+			User:       e.User,
+			Method:     e.Method,
+			URI:        e.URI,
+			Proto:      e.Proto,
+			Status:     e.Status,
+			Bytes:      e.Bytes,
+			DurationMs: float64(e.Duration) / float64(time.Millisecond),
+			Referer:    e.Referer,
+			UserAgent:  e.UserAgent,
+			Route:      e.Route,
+			RequestID:  e.RequestID,
+		})
+		buf.Write(line)
+
+	case TemplateLogFormat:
+		if err := al.template.Execute(&buf, e); err != nil {
+			buf.Reset()
+			buf.WriteString("access log template error: " + err.Error())
+		}
+
+	default:
+		size := "-"
+		if e.Bytes > 0 {
+			size = strconv.FormatInt(e.Bytes, 10)
+		}
+		buf.WriteString(dashIfEmpty(e.RemoteAddr) + " - " + dashIfEmpty(e.User) + " [" + e.Time.Format("02/Jan/2006:15:04:05 -0700") + "] ")
+		buf.WriteString(strconv.Quote(e.Method+" "+e.URI+" "+e.Proto) + " " + strconv.Itoa(e.Status) + " " + size)
+		if al.format == CombinedLogFormat {
+			buf.WriteString(" " + strconv.Quote(dashIfEmpty(e.Referer)) + " " + strconv.Quote(dashIfEmpty(e.UserAgent)))
+		}
+	}
+
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// Log writes an entry to the output of the logger
+func (al *AccessLogger) Log(e *AccessLogEntry) {
+	line := al.formatEntry(e)
+	al.mutex.Lock()
+	al.out.Write(line)
+	al.mutex.Unlock()
+}
+
+// Handler logs the requests of a single handler
+func (al *AccessLogger) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		start := time.Now()
+		writer := router.WrapResponseWriter(w)
+		handler(writer, r, p)
+		al.Log(makeAccessLogEntry(r, start, writer, p.Route()))
+	}
+}
+
+// Global returns a router that logs all the requests
+func (al *AccessLogger) Global(inner http.Handler) http.Handler {
+	return &accessLogRouter{inner: inner, logger: al}
+}
+
+func (lr *accessLogRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	writer := router.WrapResponseWriter(w)
+	r, tracker := router.TrackRoute(r)
+	lr.inner.ServeHTTP(writer, r)
+	lr.logger.Log(makeAccessLogEntry(r, start, writer, tracker.Route()))
+}
@@ -0,0 +1,68 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	var buf bytes.Buffer
+
+	rt := router.MakeRouter()
+	rt.GET("/users/:id", printHello)
+	rt.GET("/silent", func(http.ResponseWriter, *http.Request, *router.ParameterList) {})
+
+	headers := map[string]string{"User-Agent": "test-agent", "Referer": "https://example.com"}
+
+	common := NewAccessLogger(AccessLogConfig{Output: &buf, Format: CommonLogFormat}).Global(rt)
+	runRequest(common, "GET", "/users/42", headers)
+	if !regexp.MustCompile(`^192\.0\.2\.1 - - \[.+\] "GET /users/42 HTTP/1\.1" 200 5\n$`).Match(buf.Bytes()) {
+		t.Errorf("Unexpected common log: %s", buf.String())
+	}
+
+	buf.Reset()
+	combined := NewAccessLogger(AccessLogConfig{Output: &buf, Format: CombinedLogFormat}).Global(rt)
+	runRequest(combined, "GET", "/silent", headers)
+	if !regexp.MustCompile(`"GET /silent HTTP/1\.1" 200 - "https://example\.com" "test-agent"\n$`).Match(buf.Bytes()) {
+		t.Errorf("Unexpected combined log: %s", buf.String())
+	}
+
+	buf.Reset()
+	jsonLogger := NewAccessLogger(AccessLogConfig{Output: &buf, Format: JSONLogFormat})
+	rt.GET("/logged/:id", jsonLogger.Handler(printHello))
+	runRequest(rt, "GET", "/logged/1", headers)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil || entry["route"] != "/logged/:id" ||
+		entry["bytes"] != 5.0 || entry["status"] != 200.0 || entry["user_agent"] != "test-agent" {
+		t.Errorf("Unexpected json log: %s", buf.String())
+	}
+
+	buf.Reset()
+	custom := NewAccessLogger(AccessLogConfig{Output: &buf, Format: TemplateLogFormat, Template: "{{.Method}} {{.Route}} {{.Status}} {{.Bytes}}"})
+	runRequest(custom.Global(rt), "GET", "/users/42", nil)
+	runRequest(custom.Global(rt), "GET", "/none", nil)
+	if buf.String() != "GET /users/:id 200 5\nGET  404 9\n" {
+		t.Errorf("Unexpected template log: %s", buf.String())
+	}
+}
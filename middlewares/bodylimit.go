@@ -0,0 +1,265 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// ErrBodyTooLarge is returned while reading a body bigger than the limit
+// handlers that return it (or an error that wraps it) get a 413
+var ErrBodyTooLarge = router.NewHTTPError(http.StatusRequestEntityTooLarge, "Request body is too large")
+
+// ErrBadEncoding is returned when a compressed body can't be decoded
+var ErrBadEncoding = router.NewHTTPError(http.StatusBadRequest, "Request body is not correctly encoded")
+
+// BodyLimitConfig describes the accepted request bodies
+type BodyLimitConfig struct {
+	// MaxBytes is the maximum size of the body as sent by the client
+	MaxBytes int64
+	// MaxDecompressedBytes is the maximum size of a body after decompression, default is MaxBytes
+	MaxDecompressedBytes int64
+	// KeepEncoding disables the decompression of gzip and deflate bodies
+	KeepEncoding bool
+	// TooLarge writes the responce for bodies over the limit, default is a 413 problem
+	TooLarge router.RequestHandler
+}
+
+// BodyLimit caps the size of request bodies, gzip and deflate bodies are also capped once decompressed
+type BodyLimit struct {
+	maxBytes        int64
+	maxDecompressed int64
+	decompress      bool
+	tooLarge        router.RequestHandler
+}
+
+// reader that fails once more than limit bytes are read
+type limitedBody struct {
+	r        io.Reader
+	closer   io.Closer
+	left     int64
+	exceeded bool
+}
+
+// turns corrupted compressed data into ErrBadEncoding so handlers can return it as is
+type decodeErrors struct {
+	r io.Reader
+}
+
+type bodyLimitRouter struct {
+	inner http.Handler
+	limit *BodyLimit
+}
+
+//*********************************************************************************************************************
+// limitedBody
+
+func (lb *limitedBody) Read(b []byte) (int, error) {
+	if lb.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	// read one byte over the limit to know if the body is too big
+	if int64(len(b)) > lb.left+1 {
+		b = b[:lb.left+1]
+	}
+	n, err := lb.r.Read(b)
+	if int64(n) > lb.left {
+		lb.exceeded = true
+		n = int(lb.left)
+		lb.left = 0
+		return n, ErrBodyTooLarge
+	}
+	lb.left -= int64(n)
+	return n, err
+}
+
+func (lb *limitedBody) Close() error {
+	return lb.closer.Close()
+}
+
+// check if a read body went over the limit
+func (lb *limitedBody) tooLarge() bool {
+	if lb == nil {
+		return false
+	}
+	if lb.exceeded {
+		return true
+	}
+	// a decompressed body is also too large when the compressed one went over the limit
+	if inner, ok := lb.closer.(*limitedBody); ok {
+		return inner.exceeded
+	}
+	return false
+}
+
+func (de *decodeErrors) Read(b []byte) (int, error) {
+	n, err := de.r.Read(b)
+	if err != nil && err != io.EOF && err != ErrBodyTooLarge {
+		if _, isHTTP := err.(*router.HTTPError); !isHTTP {
+			err = &router.HTTPError{Status: http.StatusBadRequest, Message: ErrBadEncoding.Message, Err: err}
+		}
+	}
+	return n, err
+}
+
+//*********************************************************************************************************************
+// BodyLimit
+
+// default responce for bodies over the limit
+func defaultTooLarge(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+	router.RenderProblem(w, r, &router.Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(http.StatusRequestEntityTooLarge),
+		Status:   http.StatusRequestEntityTooLarge,
+		Detail:   ErrBodyTooLarge.Message,
+		Instance: r.URL.Path,
+	})
+}
+
+// NewBodyLimit creates a body limit middleware, it panics if the limit is not positive
+func NewBodyLimit(config BodyLimitConfig) *BodyLimit {
+	if config.MaxBytes <= 0 {
+		panic("Body limit must be positive")
+	}
+
+	bl := &BodyLimit{
+		maxBytes:        config.MaxBytes,
+		maxDecompressed: config.MaxDecompressedBytes,
+		decompress:      !config.KeepEncoding,
+		tooLarge:        config.TooLarge,
+	}
+	if bl.maxDecompressed <= 0 {
+		bl.maxDecompressed = bl.maxBytes
+	}
+	if bl.tooLarge == nil {
+		bl.tooLarge = defaultTooLarge
+	}
+	return bl
+}
+
+// wrap the body of a request, returns a copy of the request with the limited body or the status used
+// to reject it, the request of outer middlewares is never changed
+func (bl *BodyLimit) wrap(r *http.Request) (*http.Request, *limitedBody, int) {
+	if r.ContentLength > bl.maxBytes {
+		return nil, nil, http.StatusRequestEntityTooLarge
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, nil, 0
+	}
+
+	limited := new(http.Request)
+	*limited = *r
+	raw := &limitedBody{r: r.Body, closer: r.Body, left: bl.maxBytes}
+	limited.Body = raw
+
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if !bl.decompress || (encoding != "gzip" && encoding != "x-gzip" && encoding != "deflate") {
+		return limited, raw, 0
+	}
+
+	var decoder io.Reader
+	var err error
+	if encoding == "deflate" {
+		// http deflate is the zlib format (RFC 1950)
+		decoder, err = zlib.NewReader(raw)
+	} else {
+		decoder, err = gzip.NewReader(raw)
+	}
+	if err != nil {
+		if raw.exceeded {
+			return nil, nil, http.StatusRequestEntityTooLarge
+		}
+		return nil, nil, http.StatusBadRequest
+	}
+
+	// the handler sees a plain body of unknown size
+	limited.Header = r.Header.Clone()
+	limited.Header.Del("Content-Encoding")
+	limited.Header.Del("Content-Length")
+	limited.ContentLength = -1
+	decoded := &limitedBody{r: &decodeErrors{decoder}, closer: raw, left: bl.maxDecompressed}
+	limited.Body = decoded
+	return limited, decoded, 0
+}
+
+// reject a request before it reaches the handler
+func (bl *BodyLimit) reject(w http.ResponseWriter, r *http.Request, p *router.ParameterList, status int) {
+	if status == http.StatusRequestEntityTooLarge {
+		bl.tooLarge(w, r, p)
+		return
+	}
+	router.RenderProblem(w, r, &router.Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   ErrBadEncoding.Message,
+		Instance: r.URL.Path,
+	})
+}
+
+// Handler limits the body of a single handler
+// if the handler reads a body that is too large and writes nothing the 413 responce is sent for it
+func (bl *BodyLimit) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		limited, body, status := bl.wrap(r)
+		if status != 0 {
+			bl.reject(w, r, p, status)
+			return
+		}
+		writer := router.WrapResponseWriter(w)
+		handler(writer, limited, p)
+		if body.tooLarge() && !writer.HeaderWritten() {
+			bl.tooLarge(writer, limited, p)
+		}
+	}
+}
+
+// Global returns a router that limits the body of all the requests
+func (bl *BodyLimit) Global(inner http.Handler) http.Handler {
+	return &bodyLimitRouter{inner: inner, limit: bl}
+}
+
+func (br *bodyLimitRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limited, body, status := br.limit.wrap(r)
+	if status != 0 {
+		br.limit.reject(w, r, nil, status)
+		return
+	}
+	writer := router.WrapResponseWriter(w)
+	br.inner.ServeHTTP(writer, limited)
+	if body.tooLarge() && !writer.HeaderWritten() {
+		br.limit.tooLarge(writer, limited, nil)
+	}
+}
+
+//*********************************************************************************************************************
+
+// LimitBody limits the body of a handler to maxBytes (also after decompression)
+func LimitBody(maxBytes int64, handler router.RequestHandler) router.RequestHandler {
+	return NewBodyLimit(BodyLimitConfig{MaxBytes: maxBytes}).Handler(handler)
+}
+
+// GlobalLimitBody returns a router that limits the body of all the requests to maxBytes (also after decompression)
+func GlobalLimitBody(maxBytes int64, router http.Handler) http.Handler {
+	return NewBodyLimit(BodyLimitConfig{MaxBytes: maxBytes}).Global(router)
+}
@@ -0,0 +1,126 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func gzipData(data string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(data))
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestBodyLimit(t *testing.T) {
+	echo := func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) error {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		w.Write(body)
+		return nil
+	}
+	silent := func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		ioutil.ReadAll(r.Body)
+	}
+
+	rt := router.MakeRouter()
+	rt.SetLogger(router.NopLogger{})
+	rt.POST("/echo", NewBodyLimit(BodyLimitConfig{MaxBytes: 100, MaxDecompressedBytes: 1000}).Handler(rt.WrapErr(echo)))
+	rt.POST("/silent", LimitBody(10, silent))
+
+	send := func(path string, body []byte, encoding string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		res := httptest.NewRecorder()
+		rt.ServeHTTP(res, req)
+		return res
+	}
+
+	if res := send("/echo", []byte("hello"), "", false); res.Code != 200 || res.Body.String() != "hello" {
+		t.Errorf("Small body rejected: %d", res.Code)
+	}
+	if res := send("/echo", make([]byte, 101), "", false); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Content-Length over the limit accepted: %d", res.Code)
+	}
+	if res := send("/echo", make([]byte, 101), "", true); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Chunked body over the limit accepted: %d", res.Code)
+	}
+	if res := send("/silent", make([]byte, 50), "", true); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Handler that writes nothing should get a 413: %d", res.Code)
+	}
+
+	text := strings.Repeat("a", 500)
+	if res := send("/echo", gzipData(text), "gzip", false); res.Code != 200 || res.Body.String() != text {
+		t.Errorf("Gzip body not decompressed: %d", res.Code)
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(text))
+	zw.Close()
+	if res := send("/echo", buf.Bytes(), "deflate", false); res.Code != 200 || res.Body.String() != text {
+		t.Errorf("Deflate body not decompressed: %d", res.Code)
+	}
+
+	// a small compressed body that expands over the cap
+	if res := send("/echo", gzipData(strings.Repeat("a", 5000)), "gzip", false); res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Decompression bomb accepted: %d", res.Code)
+	}
+	if res := send("/echo", []byte("not gzip at all"), "gzip", false); res.Code != http.StatusBadRequest {
+		t.Errorf("Corrupted body accepted: %d", res.Code)
+	}
+}
+
+func TestBodyLimitKeepsOuterRequest(t *testing.T) {
+	var inner *http.Request
+	handler := GlobalLimitBody(1000, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inner = r
+		ioutil.ReadAll(r.Body)
+	}))
+
+	data := gzipData("hello")
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Content-Length", "10")
+	body := req.Body
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if inner == req || inner.Header.Get("Content-Encoding") != "" || inner.ContentLength != -1 {
+		t.Errorf("Inner handler did not get a decoded copy: %v %d", inner.Header, inner.ContentLength)
+	}
+	if req.Body != body || req.ContentLength != int64(len(data)) ||
+		req.Header.Get("Content-Encoding") != "gzip" || req.Header.Get("Content-Length") != "10" {
+		t.Errorf("Outer request changed: %v %d", req.Header, req.ContentLength)
+	}
+}
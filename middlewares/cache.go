@@ -0,0 +1,569 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// ResponseCacheConfig describes which responces are kept and for how long
+type ResponseCacheConfig struct {
+	// MaxEntries is the number of responces kept, the least recently used are removed first, default is 1000
+	MaxEntries int
+	// MaxBodySize is the biggest body that is cached, default is 1MB
+	MaxBodySize int
+	// DefaultTTL is used for responces without max-age or s-maxage, 0 caches only responces with an explicit age
+	DefaultTTL time.Duration
+	// StaleWhileRevalidate is used for responces without the stale-while-revalidate directive
+	StaleWhileRevalidate time.Duration
+}
+
+// ResponseCache is a server side LRU cache for GET and HEAD requests
+// responces are cached only if their Cache-Control allows shared caches
+type ResponseCache struct {
+	maxEntries  int
+	maxBodySize int
+	defaultTTL  time.Duration
+	defaultSWR  time.Duration
+	now         func() time.Time
+
+	mutex   sync.Mutex
+	lru     *list.List                // front is the most recently used entry
+	entries map[string]*list.Element  // full key -> *cacheEntry
+	vary    map[string]*cacheVariants // base key -> request headers that select the variant
+}
+
+// variants stored for a base key, removed with the last entry
+type cacheVariants struct {
+	headers []string
+	entries int
+}
+
+// a cached responce
+type cacheEntry struct {
+	key          string
+	base         string
+	vary         []string
+	status       int
+	header       http.Header
+	body         []byte
+	stored       time.Time
+	expires      time.Time
+	staleUntil   time.Time
+	pattern      string
+	tags         []string
+	revalidating bool
+}
+
+// writer that records a responce, w is nil for background revalidations
+type cacheRecorder struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	wroteHeader bool
+	snapshot    http.Header
+	body        []byte
+	limit       int
+	overflow    bool
+}
+
+// serves a request and returns the matched route
+type cachedServe func(http.ResponseWriter, *http.Request) *router.Route
+
+// context that keeps the values of a request but is never canceled
+type detachedContext struct {
+	context.Context
+}
+
+type responseCacheRouter struct {
+	inner http.Handler
+	cache *ResponseCache
+}
+
+//*********************************************************************************************************************
+// cache keys and directives
+
+// key of a request without the vary headers, the query is sorted so the order of parameters does not matter
+// only GET responces are stored and HEAD requests are answered with them
+func cacheBaseKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// add the values of the vary headers to a base key
+func cacheVariantKey(base string, r *http.Request, vary []string) string {
+	if len(vary) == 0 {
+		return base
+	}
+	var sb strings.Builder
+	sb.WriteString(base)
+	for _, name := range vary {
+		sb.WriteByte('\n')
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return sb.String()
+}
+
+// parse the request headers listed in Vary, returns false for Vary: *
+func parseVary(h http.Header) ([]string, bool) {
+	var names []string
+	for _, line := range h.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// check if a canonical header name is in a parsed Vary list
+func varyContains(vary []string, name string) bool {
+	for _, v := range vary {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parse a Cache-Control header into a map of directives
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.IndexByte(part, '='); i != -1 {
+			name, arg = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = arg
+	}
+	return directives
+}
+
+// seconds of a directive
+func directiveSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+//*********************************************************************************************************************
+// ResponseCache
+
+// NewResponseCache creates an empty responce cache
+func NewResponseCache(config ResponseCacheConfig) *ResponseCache {
+	rc := &ResponseCache{
+		maxEntries:  config.MaxEntries,
+		maxBodySize: config.MaxBodySize,
+		defaultTTL:  config.DefaultTTL,
+		defaultSWR:  config.StaleWhileRevalidate,
+		now:         time.Now,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+		vary:        make(map[string]*cacheVariants),
+	}
+	if rc.maxEntries <= 0 {
+		rc.maxEntries = 1000
+	}
+	if rc.maxBodySize <= 0 {
+		rc.maxBodySize = 1 << 20
+	}
+	return rc
+}
+
+// find the entry of a request
+func (rc *ResponseCache) lookup(r *http.Request) *cacheEntry {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	base := cacheBaseKey(r)
+	variants, ok := rc.vary[base]
+	if !ok {
+		return nil
+	}
+	el, ok := rc.entries[cacheVariantKey(base, r, variants.headers)]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cacheEntry)
+	if rc.now().After(entry.staleUntil) {
+		rc.removeLocked(el)
+		return nil
+	}
+	rc.lru.MoveToFront(el)
+	return entry
+}
+
+// remove an entry, the lock must be held
+func (rc *ResponseCache) removeLocked(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	rc.lru.Remove(el)
+	delete(rc.entries, entry.key)
+	if variants := rc.vary[entry.base]; variants != nil {
+		variants.entries--
+		if variants.entries <= 0 {
+			delete(rc.vary, entry.base)
+		}
+	}
+}
+
+// build an entry from a recorded responce, returns nil if the responce can't be cached
+func (rc *ResponseCache) makeEntry(r *http.Request, rec *cacheRecorder, route *router.Route) *cacheEntry {
+	if rec.status != http.StatusOK || rec.overflow || rec.snapshot == nil || rec.snapshot.Get("Set-Cookie") != "" {
+		return nil
+	}
+	if _, noStore := parseCacheControl(r.Header.Get("Cache-Control"))["no-store"]; noStore {
+		return nil
+	}
+
+	directives := parseCacheControl(rec.snapshot.Get("Cache-Control"))
+	for _, forbidden := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[forbidden]; ok {
+			return nil
+		}
+	}
+	// responces to authenticated requests are shared only if explicitly allowed (RFC 9111 3.5)
+	if r.Header.Get("Authorization") != "" {
+		_, public := directives["public"]
+		_, shared := directives["s-maxage"]
+		if !public && !shared {
+			return nil
+		}
+	}
+
+	vary, cacheable := parseVary(rec.snapshot)
+	if !cacheable {
+		return nil
+	}
+	// cookies usually identify the user, the responce must be keyed by them
+	if r.Header.Get("Cookie") != "" && !varyContains(vary, "Cookie") {
+		return nil
+	}
+	ttl, ok := directiveSeconds(directives, "s-maxage")
+	if !ok {
+		ttl, ok = directiveSeconds(directives, "max-age")
+	}
+	if !ok {
+		ttl = rc.defaultTTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	swr, ok := directiveSeconds(directives, "stale-while-revalidate")
+	if !ok {
+		swr = rc.defaultSWR
+	}
+
+	now := rc.now()
+	base := cacheBaseKey(r)
+	entry := &cacheEntry{
+		key:        cacheVariantKey(base, r, vary),
+		base:       base,
+		vary:       vary,
+		status:     rec.status,
+		header:     rec.snapshot,
+		body:       rec.body,
+		stored:     now,
+		expires:    now.Add(ttl),
+		staleUntil: now.Add(ttl + swr),
+	}
+	if route != nil {
+		entry.pattern = route.Pattern()
+		entry.tags = route.Metadata.Tags
+	}
+	return entry
+}
+
+// add an entry and remove the least recently used ones over the limit
+func (rc *ResponseCache) store(entry *cacheEntry) {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	if el, ok := rc.entries[entry.key]; ok {
+		rc.removeLocked(el)
+	}
+	variants := rc.vary[entry.base]
+	if variants == nil {
+		variants = &cacheVariants{}
+		rc.vary[entry.base] = variants
+	}
+	// the latest responce decides the headers used to find the variants
+	variants.headers = entry.vary
+	variants.entries++
+	rc.entries[entry.key] = rc.lru.PushFront(entry)
+	for rc.lru.Len() > rc.maxEntries {
+		rc.removeLocked(rc.lru.Back())
+	}
+}
+
+// write a cached entry to the client
+func (rc *ResponseCache) writeEntry(w http.ResponseWriter, r *http.Request, entry *cacheEntry, state string) {
+	h := w.Header()
+	for k, v := range entry.header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.Itoa(int(rc.now().Sub(entry.stored)/time.Second)))
+	h.Set("X-Cache", state)
+	w.WriteHeader(entry.status)
+	if r.Method != "HEAD" {
+		w.Write(entry.body)
+	}
+}
+
+// start a background revalidation of a stale entry, only one runs for every entry
+func (rc *ResponseCache) revalidate(r *http.Request, entry *cacheEntry, serve cachedServe) {
+	rc.mutex.Lock()
+	if entry.revalidating {
+		rc.mutex.Unlock()
+		return
+	}
+	entry.revalidating = true
+	rc.mutex.Unlock()
+
+	// the client request can end before the handler
+	req := r.Clone(detachedContext{r.Context()})
+	go func() {
+		defer func() {
+			recover() // a failed revalidation keeps the stale entry until it expires
+			rc.mutex.Lock()
+			entry.revalidating = false
+			rc.mutex.Unlock()
+		}()
+
+		rec := &cacheRecorder{header: make(http.Header), limit: rc.maxBodySize}
+		route := serve(rec, req)
+		if fresh := rc.makeEntry(req, rec, route); fresh != nil {
+			rc.store(fresh)
+		}
+	}()
+}
+
+// serve a request from the cache or run the handler and store its responce
+// serve must be safe to call after the request is completed, it is used for revalidations
+func (rc *ResponseCache) serve(w http.ResponseWriter, r *http.Request, serve cachedServe) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		serve(w, r)
+		return
+	}
+
+	requestDirectives := parseCacheControl(r.Header.Get("Cache-Control"))
+	if _, noCache := requestDirectives["no-cache"]; !noCache {
+		if entry := rc.lookup(r); entry != nil {
+			if rc.now().Before(entry.expires) {
+				rc.writeEntry(w, r, entry, "HIT")
+				return
+			}
+			rc.writeEntry(w, r, entry, "STALE")
+			rc.revalidate(r, entry, serve)
+			return
+		}
+	}
+
+	rec := &cacheRecorder{w: w, limit: rc.maxBodySize}
+	w.Header().Set("X-Cache", "MISS")
+	route := serve(rec, r)
+	if r.Method == "HEAD" {
+		return
+	}
+	if entry := rc.makeEntry(r, rec, route); entry != nil {
+		entry.header.Del("X-Cache")
+		rc.store(entry)
+	}
+}
+
+// Handler caches the responces of a single handler
+func (rc *ResponseCache) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		// revalidations run after the router has reused the parameters
+		params := p.Clone()
+		rc.serve(w, r, func(rw http.ResponseWriter, req *http.Request) *router.Route {
+			handler(rw, req, params)
+			return params.Route()
+		})
+	}
+}
+
+// Global returns a router that caches all the responces
+func (rc *ResponseCache) Global(inner http.Handler) http.Handler {
+	return &responseCacheRouter{inner: inner, cache: rc}
+}
+
+func (cr *responseCacheRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cr.cache.serve(w, r, func(rw http.ResponseWriter, req *http.Request) *router.Route {
+		req, tracker := router.TrackRoute(req)
+		cr.inner.ServeHTTP(rw, req)
+		return tracker.Route()
+	})
+}
+
+// purge all the entries that match a function, returns the number of removed entries
+func (rc *ResponseCache) purge(match func(*cacheEntry) bool) int {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	removed := 0
+	for el := rc.lru.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*cacheEntry)) {
+			rc.removeLocked(el)
+			removed++
+		}
+		el = next
+	}
+	return removed
+}
+
+// PurgePattern removes the responces of a route pattern (es: /users/:id)
+func (rc *ResponseCache) PurgePattern(pattern string) int {
+	return rc.purge(func(e *cacheEntry) bool { return e.pattern == pattern })
+}
+
+// PurgeTag removes the responces of the routes that have a tag in their metadata
+func (rc *ResponseCache) PurgeTag(tag string) int {
+	return rc.purge(func(e *cacheEntry) bool {
+		for _, t := range e.tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// PurgePath removes all the responces of a path, with any query and variant
+func (rc *ResponseCache) PurgePath(path string) int {
+	return rc.purge(func(e *cacheEntry) bool { return strings.HasPrefix(e.base, path+"?") })
+}
+
+// Purge removes all the responces
+func (rc *ResponseCache) Purge() int {
+	return rc.purge(func(*cacheEntry) bool { return true })
+}
+
+// Len returns the number of cached responces
+func (rc *ResponseCache) Len() int {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	return rc.lru.Len()
+}
+
+//*********************************************************************************************************************
+// cacheRecorder and detachedContext
+
+func (cr *cacheRecorder) Header() http.Header {
+	if cr.w != nil {
+		return cr.w.Header()
+	}
+	return cr.header
+}
+
+func (cr *cacheRecorder) WriteHeader(code int) {
+	if cr.wroteHeader {
+		return
+	}
+	cr.wroteHeader = true
+	cr.status = code
+	cr.snapshot = cr.Header().Clone()
+	if cr.w != nil {
+		cr.w.WriteHeader(code)
+	}
+}
+
+func (cr *cacheRecorder) Write(b []byte) (int, error) {
+	if !cr.wroteHeader {
+		cr.WriteHeader(http.StatusOK)
+	}
+	if !cr.overflow {
+		if len(cr.body)+len(b) > cr.limit {
+			cr.overflow = true
+			cr.body = nil
+		} else {
+			cr.body = append(cr.body, b...)
+		}
+	}
+	if cr.w != nil {
+		return cr.w.Write(b)
+	}
+	return len(b), nil
+}
+
+// Flush implements http.Flusher
+func (cr *cacheRecorder) Flush() {
+	if f, ok := cr.w.(http.Flusher); ok {
+		if !cr.wroteHeader {
+			cr.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, a hijacked responce is never stored
+func (cr *cacheRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cr.w.(http.Hijacker); ok {
+		cr.overflow = true
+		cr.body = nil
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the original writer (nil for background revalidations), used by http.ResponseController
+func (cr *cacheRecorder) Unwrap() http.ResponseWriter {
+	return cr.w
+}
+
+// Deadline implements context.Context
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements context.Context
+func (detachedContext) Err() error {
+	return nil
+}
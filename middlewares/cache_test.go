@@ -0,0 +1,224 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls int32
+	counter := func(cacheControl string) router.RequestHandler {
+		return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+			n := atomic.AddInt32(&calls, 1)
+			w.Header().Set("Cache-Control", cacheControl)
+			w.Header().Set("Vary", "Accept-Language")
+			w.Write([]byte(p.Get("id") + ":" + r.Header.Get("Accept-Language") + ":" + strconv.Itoa(int(n))))
+		}
+	}
+
+	now := time.Now()
+	cache := NewResponseCache(ResponseCacheConfig{MaxEntries: 3})
+	cache.now = func() time.Time { return now }
+
+	rt := router.MakeRouter()
+	rt.GET("/items/:id", counter("public, max-age=60, stale-while-revalidate=30")).WithTags("items")
+	rt.GET("/private", counter("private, max-age=60"))
+	handler := cache.Global(rt)
+
+	get := func(path, lang string) (string, string) {
+		res := runRequest(handler, "GET", path, map[string]string{"Accept-Language": lang})
+		return res.Body.String(), res.Header().Get("X-Cache")
+	}
+
+	if body, state := get("/items/1?b=2&a=1", "en"); body != "1:en:1" || state != "MISS" {
+		t.Fatalf("Unexpected first responce: %s %s", body, state)
+	}
+	if body, state := get("/items/1?a=1&b=2", "en"); body != "1:en:1" || state != "HIT" {
+		t.Errorf("Query order should not change the key: %s %s", body, state)
+	}
+	if body, _ := get("/items/1?a=1&b=2", "it"); body != "1:it:2" {
+		t.Errorf("Vary header ignored: %s", body)
+	}
+	if body, _ := get("/private", "en"); body != ":en:3" {
+		t.Errorf("Unexpected private responce: %s", body)
+	}
+	if _, state := get("/private", "en"); state != "MISS" {
+		t.Errorf("Private responce cached")
+	}
+
+	// stale entries are served while a new responce is computed
+	now = now.Add(70 * time.Second)
+	if body, state := get("/items/1?a=1&b=2", "en"); body != "1:en:1" || state != "STALE" {
+		t.Errorf("Stale entry not served: %s %s", body, state)
+	}
+	body, state := get("/items/1?a=1&b=2", "en")
+	for i := 0; i < 100 && state != "HIT"; i++ {
+		time.Sleep(5 * time.Millisecond)
+		body, state = get("/items/1?a=1&b=2", "en")
+	}
+	if body != "1:en:5" || state != "HIT" {
+		t.Errorf("Entry not revalidated: %s %s", body, state)
+	}
+
+	// expired after the stale window
+	now = now.Add(2 * time.Minute)
+	if _, state := get("/items/1?a=1&b=2", "en"); state != "MISS" {
+		t.Errorf("Expired entry served: %s", state)
+	}
+
+	get("/items/2", "en")
+	if res := runRequest(handler, "HEAD", "/items/2", map[string]string{"Accept-Language": "en"}); res.Body.Len() != 0 || res.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("HEAD should use the cached GET: %v", res.Header())
+	}
+	get("/items/3", "en")
+	get("/items/4", "en")
+	if cache.Len() != 3 {
+		t.Errorf("LRU limit not applied: %d", cache.Len())
+	}
+	if n := cache.PurgePattern("/items/:id"); n != 3 || cache.Len() != 0 {
+		t.Errorf("Purge by pattern removed %d", n)
+	}
+	get("/items/2", "en")
+	if n := cache.PurgeTag("items"); n != 1 {
+		t.Errorf("Purge by tag removed %d", n)
+	}
+}
+
+func TestResponseCacheHandler(t *testing.T) {
+	calls := 0
+	cache := NewResponseCache(ResponseCacheConfig{DefaultTTL: time.Minute})
+
+	rt := router.MakeRouter()
+	rt.GET("/users/:name", cache.Handler(func(w http.ResponseWriter, _ *http.Request, p *router.ParameterList) {
+		calls++
+		w.Write([]byte(p.Get("name")))
+	}))
+	rt.GET("/nostore", cache.Handler(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		calls++
+		w.Header().Set("Cache-Control", "no-store")
+	}))
+
+	runRequest(rt, "GET", "/users/ann", nil)
+	if res := runRequest(rt, "GET", "/users/ann", nil); res.Body.String() != "ann" || calls != 1 {
+		t.Errorf("Cached responce not used: %s %d", res.Body.String(), calls)
+	}
+	if res := runRequest(rt, "GET", "/users/ann", map[string]string{"Cache-Control": "no-cache"}); res.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Request no-cache should skip the cache")
+	}
+	runRequest(rt, "GET", "/nostore", nil)
+	runRequest(rt, "GET", "/nostore", nil)
+	if calls != 4 {
+		t.Errorf("no-store responce cached: %d", calls)
+	}
+	if cache.PurgePath("/users/ann") != 1 {
+		t.Errorf("Purge by path failed")
+	}
+}
+
+func TestResponseCacheKeepsWriterInterfaces(t *testing.T) {
+	cache := NewResponseCache(ResponseCacheConfig{DefaultTTL: time.Minute})
+	rt := router.MakeRouter()
+	rt.GET("/socket", cache.Handler(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Write([]byte("upgraded"))
+		if _, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok {
+			t.Errorf("Writer can't be unwrapped")
+		}
+		if _, _, err := w.(http.Hijacker).Hijack(); err != nil {
+			t.Errorf("Hijack failed: %v", err)
+		}
+	}))
+
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rt.ServeHTTP(recorder, httptest.NewRequest("GET", "/socket", nil))
+	if !recorder.hijacked || cache.Len() != 0 {
+		t.Errorf("Hijacked responce was cached")
+	}
+}
+
+func TestResponseCacheVaryBounded(t *testing.T) {
+	cache := NewResponseCache(ResponseCacheConfig{MaxEntries: 10, DefaultTTL: time.Minute})
+	rt := router.MakeRouter()
+	rt.GET("/items/:id", cache.Handler(func(w http.ResponseWriter, _ *http.Request, p *router.ParameterList) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(p.Get("id")))
+	}))
+
+	for i := 0; i < 1000; i++ {
+		runRequest(rt, "GET", "/items/"+strconv.Itoa(i), nil)
+	}
+	if cache.Len() != 10 || len(cache.vary) != 10 {
+		t.Errorf("Vary keys not removed with their entries: %d entries, %d vary keys", cache.Len(), len(cache.vary))
+	}
+	cache.PurgePattern("/items/:id")
+	if len(cache.vary) != 0 {
+		t.Errorf("Vary keys not removed by purge: %d", len(cache.vary))
+	}
+}
+
+func TestResponseCacheSharedByUsers(t *testing.T) {
+	cache := NewResponseCache(ResponseCacheConfig{DefaultTTL: time.Minute})
+	secret := func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		user, _, _ := r.BasicAuth()
+		if c, err := r.Cookie("session"); err == nil {
+			user = c.Value
+		}
+		w.Write([]byte("secret of " + user))
+	}
+
+	rt := router.MakeRouter()
+	rt.GET("/auth", cache.Handler(secret))
+	rt.GET("/cookie", cache.Handler(secret))
+	rt.GET("/public", cache.Handler(func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		secret(w, r, p)
+	}))
+	rt.GET("/vary", cache.Handler(func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		w.Header().Set("Vary", "Cookie")
+		secret(w, r, p)
+	}))
+
+	alice := map[string]string{"Authorization": "Basic YWxpY2U6eA=="} // alice:x
+	bob := map[string]string{"Authorization": "Basic Ym9iOng="}       // bob:x
+	runRequest(rt, "GET", "/auth", alice)
+	if res := runRequest(rt, "GET", "/auth", bob); res.Body.String() != "secret of bob" {
+		t.Errorf("Authenticated responce shared: %s %s", res.Body.String(), res.Header().Get("X-Cache"))
+	}
+	runRequest(rt, "GET", "/public", alice)
+	if res := runRequest(rt, "GET", "/public", bob); res.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Public responce to authenticated request not cached")
+	}
+
+	runRequest(rt, "GET", "/cookie", map[string]string{"Cookie": "session=alice"})
+	if res := runRequest(rt, "GET", "/cookie", map[string]string{"Cookie": "session=bob"}); res.Body.String() != "secret of bob" {
+		t.Errorf("Cookie responce shared: %s", res.Body.String())
+	}
+	runRequest(rt, "GET", "/vary", map[string]string{"Cookie": "session=alice"})
+	if res := runRequest(rt, "GET", "/vary", map[string]string{"Cookie": "session=bob"}); res.Body.String() != "secret of bob" {
+		t.Errorf("Vary: Cookie ignored: %s", res.Body.String())
+	}
+	if res := runRequest(rt, "GET", "/vary", map[string]string{"Cookie": "session=alice"}); res.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Responce with Vary: Cookie not cached")
+	}
+}
@@ -0,0 +1,364 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// CompressConfig describes when and how responces are compressed
+type CompressConfig struct {
+	// Level is the compression level of gzip and deflate, 0 means default compression
+	Level int
+	// MinSize is the minimum body size (bytes) that is compressed, default is 1024
+	MinSize int
+	// SkipContentTypes are content type prefixes that are never compressed, if nil a list of already
+	// compressed formats is used (images, video, audio, archives, fonts)
+	SkipContentTypes []string
+}
+
+// Compressor gzips or deflates responces larger than MinSize for clients that accept it,
+// small bodies and already compressed content types are sent as they are
+type Compressor struct {
+	level     int
+	minSize   int
+	skipTypes []string
+	gzipPool  sync.Pool
+	zlibPool  sync.Pool
+}
+
+// writer that buffers the start of the body to decide if the responce should be compressed
+// the shared writer tracks what is really sent (compressed bytes) and forwards Push and Unwrap,
+// ReadFrom is replaced by a copy through Write because the compression must see every byte
+type compressWriter struct {
+	*router.ResponseWriter
+	c        *Compressor
+	encoding string
+	buf      []byte
+	status   int
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+type compressRouter struct {
+	inner http.Handler
+	c     *Compressor
+}
+
+var defaultSkipContentTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-7z-compressed",
+	"application/x-rar-compressed", "application/x-xz", "application/zstd", "application/octet-stream", "application/pdf",
+}
+
+var defaultCompressor = NewCompressor(CompressConfig{})
+
+//*********************************************************************************************************************
+// Compressor
+
+// NewCompressor creates a compression middleware
+func NewCompressor(config CompressConfig) *Compressor {
+	c := &Compressor{level: config.Level, minSize: config.MinSize, skipTypes: config.SkipContentTypes}
+	if c.level == 0 {
+		c.level = gzip.DefaultCompression
+	}
+	if c.minSize <= 0 {
+		c.minSize = 1024
+	}
+	if c.skipTypes == nil {
+		c.skipTypes = defaultSkipContentTypes
+	}
+
+	level := c.level
+	c.gzipPool.New = func() interface{} {
+		w, err := gzip.NewWriterLevel(ioutil.Discard, level)
+		if err != nil {
+			w = gzip.NewWriter(ioutil.Discard)
+		}
+		return w
+	}
+	c.zlibPool.New = func() interface{} {
+		w, err := zlib.NewWriterLevel(ioutil.Discard, level)
+		if err != nil {
+			w = zlib.NewWriter(ioutil.Discard)
+		}
+		return w
+	}
+
+	return c
+}
+
+// find the best supported encoding in an Accept-Encoding header, gzip wins ties
+// the * q-value is used only for codings that are not named in the header
+func negotiateEncoding(header string) string {
+	gzipQ, deflateQ, anyQ := 0.0, 0.0, 0.0
+	namedGzip, namedDeflate := false, false
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		switch name {
+		case "gzip", "x-gzip":
+			gzipQ, namedGzip = q, true
+		case "deflate":
+			deflateQ, namedDeflate = q, true
+		case "*":
+			anyQ = q
+		}
+	}
+	if !namedGzip {
+		gzipQ = anyQ
+	}
+	if !namedDeflate {
+		deflateQ = anyQ
+	}
+
+	if gzipQ > 0 && gzipQ >= deflateQ {
+		return "gzip"
+	}
+	if deflateQ > 0 {
+		return "deflate"
+	}
+	return ""
+}
+
+// wrap a writer if the request accepts a compressed responce, returns nil if compression is not possible
+func (c *Compressor) wrap(w http.ResponseWriter, r *http.Request) *compressWriter {
+	// the responce changes with the header even if this client gets it uncompressed
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	// ranges are computed on the uncompressed body
+	if r.Method == "HEAD" || r.Header.Get("Range") != "" {
+		return nil
+	}
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil
+	}
+
+	return &compressWriter{ResponseWriter: router.WrapResponseWriter(w), c: c, encoding: encoding}
+}
+
+// Handler compresses the responces of a single handler
+func (c *Compressor) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		cw := c.wrap(w, r)
+		if cw == nil {
+			handler(w, r, p)
+			return
+		}
+		defer cw.close()
+		handler(cw, r, p)
+	}
+}
+
+// Global returns a router that compresses all the responces
+func (c *Compressor) Global(inner http.Handler) http.Handler {
+	return &compressRouter{inner: inner, c: c}
+}
+
+func (cr *compressRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cw := cr.c.wrap(w, r)
+	if cw == nil {
+		cr.inner.ServeHTTP(w, r)
+		return
+	}
+	defer cw.close()
+	cr.inner.ServeHTTP(cw, r)
+}
+
+//*********************************************************************************************************************
+// compressWriter
+
+// check if the responce can be compressed, final is true if the whole body is in the buffer
+func (cw *compressWriter) shouldCompress(final bool) bool {
+	h := cw.Header()
+
+	if final && len(cw.buf) < cw.c.minSize {
+		return false
+	}
+	if cw.status == http.StatusPartialContent || h.Get("Content-Range") != "" || h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		// detect the type now because the server can't do it on the compressed body
+		contentType = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", contentType)
+	}
+	for _, skip := range cw.c.skipTypes {
+		if strings.HasPrefix(contentType, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+// decide if the body is compressed, then write the headers and the buffered body
+func (cw *compressWriter) decide(final bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.shouldCompress(final) {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+
+		if cw.encoding == "gzip" {
+			gw := cw.c.gzipPool.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.encoder = gw
+		} else {
+			// http deflate is the zlib format (RFC 1950), not raw deflate
+			zw := cw.c.zlibPool.Get().(*zlib.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.encoder = zw
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// WriteHeader delays the status until the compression is decided
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+	cw.status = code
+
+	// responces without body are sent immediately
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.decided = true
+		cw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.c.minSize {
+			return len(b), nil
+		}
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, a flush forces the decision even if the body is still small
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if gw, ok := cw.encoder.(*gzip.Writer); ok {
+		gw.Flush()
+	} else if zw, ok := cw.encoder.(*zlib.Writer); ok {
+		zw.Flush()
+	}
+	cw.ResponseWriter.Flush()
+}
+
+// Hijack implements http.Hijacker, nothing is written once the connection is taken
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := cw.ResponseWriter.Hijack()
+	if err == nil {
+		cw.decided = true
+		cw.buf = nil
+	}
+	return conn, buf, err
+}
+
+// ReadFrom implements io.ReaderFrom with a copy through Write, the ReadFrom of the wrapped writer
+// (es: sendfile) would send the body without compressing it
+func (cw *compressWriter) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{cw}, src)
+}
+
+// complete the responce and give the encoder back to the pool
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		// nothing written by the handler, let the server send its default responce
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+		cw.decide(true)
+	}
+
+	switch enc := cw.encoder.(type) {
+	case *gzip.Writer:
+		enc.Close()
+		cw.c.gzipPool.Put(enc)
+	case *zlib.Writer:
+		enc.Close()
+		cw.c.zlibPool.Put(enc)
+	}
+	cw.encoder = nil
+}
+
+//*********************************************************************************************************************
+
+// Compress compresses the responces of a handler with gzip or deflate using the default configuration
+func Compress(handler router.RequestHandler) router.RequestHandler {
+	return defaultCompressor.Handler(handler)
+}
+
+// GlobalCompress returns a router that compresses all the responces with the default configuration
+func GlobalCompress(router http.Handler) http.Handler {
+	return defaultCompressor.Global(router)
+}
@@ -0,0 +1,169 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/router"
+	"github.com/rickycorte/pantofola-rest/staticfiles"
+)
+
+var largeBody = strings.Repeat(`{"name":"pantofola","value":42}`, 100)
+
+func writeLarge(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", "999")
+	w.Write([]byte(largeBody))
+}
+
+func TestCompression(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/large", writeLarge)
+	rt.GET("/small", printHello)
+	rt.GET("/image", func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(largeBody))
+	})
+	rt.GET("/single", Compress(writeLarge))
+	handler := GlobalCompress(rt)
+
+	res := runRequest(handler, "GET", "/large", map[string]string{"Accept-Encoding": "gzip, deflate"})
+	if res.Header().Get("Content-Encoding") != "gzip" || res.Header().Get("Content-Length") != "" ||
+		res.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Unexpected headers: %v", res.Header())
+	}
+	gr, _ := gzip.NewReader(res.Body)
+	if body, _ := ioutil.ReadAll(gr); string(body) != largeBody {
+		t.Errorf("Wrong gzip body")
+	}
+
+	res = runRequest(handler, "GET", "/single", map[string]string{"Accept-Encoding": "gzip;q=0.5, deflate"})
+	if res.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Expected deflate, got: %v", res.Header())
+	}
+	zr, _ := zlib.NewReader(res.Body)
+	if body, _ := ioutil.ReadAll(zr); string(body) != largeBody {
+		t.Errorf("Wrong deflate body")
+	}
+
+	for _, path := range []string{"/small", "/image"} {
+		res = runRequest(handler, "GET", path, map[string]string{"Accept-Encoding": "gzip"})
+		if res.Header().Get("Content-Encoding") != "" || res.Code != 200 {
+			t.Errorf("%s should not be compressed", path)
+		}
+	}
+
+	res = runRequest(handler, "GET", "/large", nil)
+	if res.Header().Get("Content-Encoding") != "" || res.Body.String() != largeBody {
+		t.Errorf("Responce compressed without Accept-Encoding")
+	}
+}
+
+func TestCompressionKeepsWriterInterfaces(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/stream", Compress(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		if _, ok := w.(http.Pusher); !ok {
+			t.Errorf("Writer is not a pusher")
+		}
+		if _, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok {
+			t.Errorf("Writer can't be unwrapped")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader(largeBody))
+	}))
+	rt.GET("/socket", Compress(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Write([]byte("buffered"))
+		if _, _, err := w.(http.Hijacker).Hijack(); err != nil {
+			t.Errorf("Hijack failed: %v", err)
+		}
+	}))
+
+	res := runRequest(rt, "GET", "/stream", map[string]string{"Accept-Encoding": "gzip"})
+	gr, err := gzip.NewReader(res.Body)
+	if err != nil || res.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Body sent with ReadFrom not compressed: %v %v", err, res.Header())
+	}
+	if body, _ := ioutil.ReadAll(gr); string(body) != largeBody {
+		t.Errorf("Wrong gzip body")
+	}
+
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	req := httptest.NewRequest("GET", "/socket", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rt.ServeHTTP(recorder, req)
+	if !recorder.hijacked || recorder.Body.Len() != 0 {
+		t.Errorf("Hijacked responce was written: %q", recorder.Body.String())
+	}
+}
+
+func TestCompressionStaticFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pantofola")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "data.json"), []byte(largeBody), 0644)
+
+	rt := router.MakeRouter()
+	rt.GET("/static/:*", Compress(staticfiles.Serve(dir, func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.WriteHeader(404)
+	})))
+
+	res := runRequest(rt, "GET", "/static/data.json", map[string]string{"Accept-Encoding": "gzip"})
+	gr, err := gzip.NewReader(res.Body)
+	if err != nil || res.Header().Get("Content-Length") != "" {
+		t.Fatalf("Static file not compressed: %v", res.Header())
+	}
+	if body, _ := ioutil.ReadAll(gr); string(body) != largeBody {
+		t.Errorf("Wrong static file body")
+	}
+
+	res = runRequest(rt, "GET", "/static/data.json", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-9"})
+	if res.Code != 206 || res.Header().Get("Content-Encoding") != "" || res.Body.String() != largeBody[:10] {
+		t.Errorf("Range request should not be compressed: %d %v", res.Code, res.Header())
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"gzip":                     "gzip",
+		"x-gzip":                   "gzip",
+		"gzip, deflate":            "gzip",
+		"gzip;q=0.5, deflate":      "deflate",
+		"deflate;q=0.5, gzip;q=0":  "deflate",
+		"br":                       "",
+		"*":                        "gzip",
+		"gzip;q=0, *":              "deflate",
+		"gzip;q=0, deflate;q=0, *": "",
+		"*;q=0":                    "",
+		"identity, *;q=0.1, br":    "gzip",
+		"deflate, *;q=0.5":         "deflate",
+	}
+	for header, expected := range tests {
+		if encoding := negotiateEncoding(header); encoding != expected {
+			t.Errorf("Wrong encoding for %q: %q, expected %q", header, encoding, expected)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// ErrCSRFOrigin is returned when Origin or Referer of an unsafe request is not the server or a trusted origin
+var ErrCSRFOrigin = router.NewHTTPError(http.StatusForbidden, "Cross origin request rejected")
+
+// ErrCSRFToken is returned when the CSRF token of an unsafe request is missing or wrong
+var ErrCSRFToken = router.NewHTTPError(http.StatusForbidden, "Missing or invalid CSRF token")
+
+// CSRFConfig describes a double submit cookie CSRF protection
+type CSRFConfig struct {
+	// CookieName default is csrf_token
+	CookieName   string
+	CookiePath   string        // default is /
+	CookieDomain string        // empty for the request host only
+	CookieMaxAge time.Duration // default is 12 hours
+	// Secure sends the cookie only over https, it's always set for https requests
+	Secure   bool
+	SameSite http.SameSite // default is Lax
+	// HeaderName is checked before the form field, default is X-CSRF-Token
+	HeaderName string
+	// FormField is read from urlencoded and multipart bodies, default is csrf_token
+	FormField string
+	// TrustedOrigins are other origins (es: https://app.example.com) allowed to send unsafe requests
+	TrustedOrigins []string
+	// ExemptPaths skips the check for a path, a trailing * matches a prefix (es: /webhooks/*)
+	ExemptPaths []string
+	// Exempt skips the check when it returns true
+	Exempt func(r *http.Request) bool
+	// Failed writes the responce of rejected requests, err is ErrCSRFOrigin or ErrCSRFToken, default is a 403 problem
+	Failed router.ErrorHandler
+}
+
+// CSRFProtection checks that unsafe requests come from the server origin and carry the token of the CSRF cookie
+type CSRFProtection struct {
+	cookie         http.Cookie
+	headerName     string
+	formField      string
+	trustedOrigins map[string]bool
+	exemptPaths    []string
+	exemptPrefixes []string
+	exempt         func(r *http.Request) bool
+	failed         router.ErrorHandler
+}
+
+type csrfRouter struct {
+	inner http.Handler
+	csrf  *CSRFProtection
+}
+
+type csrfTokenKey struct{}
+
+const csrfTokenLength = 32
+
+//*********************************************************************************************************************
+// tokens
+
+// CSRFToken returns the token that pages and clients must send back in the header or form field
+// a new masked value is returned for every request so the token never appears twice in compressed responces
+// the token is empty if the request did not pass through a CSRF middleware
+func CSRFToken(r *http.Request) string {
+	raw, _ := r.Context().Value(csrfTokenKey{}).([]byte)
+	if raw == nil {
+		return ""
+	}
+
+	masked := make([]byte, 2*csrfTokenLength)
+	if _, err := rand.Read(masked[:csrfTokenLength]); err != nil {
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	for i := 0; i < csrfTokenLength; i++ {
+		masked[csrfTokenLength+i] = masked[i] ^ raw[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// decode a raw or masked token, nil if not valid
+func decodeCSRFToken(token string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil
+	}
+
+	switch len(data) {
+	case csrfTokenLength:
+		return data
+	case 2 * csrfTokenLength:
+		raw := make([]byte, csrfTokenLength)
+		for i := range raw {
+			raw[i] = data[i] ^ data[csrfTokenLength+i]
+		}
+		return raw
+	}
+	return nil
+}
+
+//*********************************************************************************************************************
+// CSRFProtection
+
+// default responce of rejected requests
+func defaultCSRFFailed(w http.ResponseWriter, r *http.Request, err error) {
+	router.RenderProblem(w, r, router.ProblemFromError(err, r))
+}
+
+// NewCSRF creates a CSRF protection
+func NewCSRF(config CSRFConfig) *CSRFProtection {
+	c := &CSRFProtection{
+		cookie: http.Cookie{
+			Name:     config.CookieName,
+			Path:     config.CookiePath,
+			Domain:   config.CookieDomain,
+			MaxAge:   int(config.CookieMaxAge / time.Second),
+			Secure:   config.Secure,
+			HttpOnly: true,
+			SameSite: config.SameSite,
+		},
+		headerName:     config.HeaderName,
+		formField:      config.FormField,
+		trustedOrigins: make(map[string]bool),
+		exempt:         config.Exempt,
+		failed:         config.Failed,
+	}
+	if c.cookie.Name == "" {
+		c.cookie.Name = "csrf_token"
+	}
+	if c.cookie.Path == "" {
+		c.cookie.Path = "/"
+	}
+	if c.cookie.MaxAge <= 0 {
+		c.cookie.MaxAge = int(12 * time.Hour / time.Second)
+	}
+	if c.cookie.SameSite == 0 {
+		c.cookie.SameSite = http.SameSiteLaxMode
+	}
+	if c.headerName == "" {
+		c.headerName = "X-CSRF-Token"
+	}
+	if c.formField == "" {
+		c.formField = "csrf_token"
+	}
+	if c.failed == nil {
+		c.failed = defaultCSRFFailed
+	}
+
+	for _, origin := range config.TrustedOrigins {
+		c.trustedOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	for _, path := range config.ExemptPaths {
+		if strings.HasSuffix(path, "*") {
+			c.exemptPrefixes = append(c.exemptPrefixes, strings.TrimSuffix(path, "*"))
+		} else {
+			c.exemptPaths = append(c.exemptPaths, path)
+		}
+	}
+	return c
+}
+
+// safe methods don't change state and are never checked
+func csrfSafeMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS" || method == "TRACE"
+}
+
+// check if the request skips the protection
+func (c *CSRFProtection) isExempt(r *http.Request) bool {
+	for _, path := range c.exemptPaths {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	for _, prefix := range c.exemptPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return c.exempt != nil && c.exempt(r)
+}
+
+// origin of the server as seen by the client (es: https://example.com)
+func requestOrigin(r *http.Request) string {
+	client := GetRemoteClient(r)
+	return client.Scheme + "://" + strings.ToLower(client.Host)
+}
+
+// check Origin, or Referer when Origin is not sent
+// requests with none of them are checked only with the token
+func (c *CSRFProtection) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return true
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+
+	origin = strings.ToLower(origin)
+	return origin == requestOrigin(r) || c.trustedOrigins[origin]
+}
+
+// token sent by the client in the header or form
+func (c *CSRFProtection) submittedToken(r *http.Request) string {
+	if token := r.Header.Get(c.headerName); token != "" {
+		return token
+	}
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") || strings.HasPrefix(contentType, "multipart/form-data") {
+		return r.PostFormValue(c.formField)
+	}
+	return ""
+}
+
+// load or create the cookie token and check unsafe requests
+// returns the request with the token in the context or an error if it must be rejected
+func (c *CSRFProtection) check(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	var raw []byte
+	if cookie, err := r.Cookie(c.cookie.Name); err == nil {
+		raw = decodeCSRFToken(cookie.Value)
+		if len(raw) != csrfTokenLength {
+			raw = nil
+		}
+	}
+	fromCookie := raw != nil
+
+	if !csrfSafeMethod(r.Method) && !c.isExempt(r) {
+		if !c.originAllowed(r) {
+			return r, ErrCSRFOrigin
+		}
+		sent := decodeCSRFToken(c.submittedToken(r))
+		if !fromCookie || sent == nil || subtle.ConstantTimeCompare(raw, sent) != 1 {
+			return r, ErrCSRFToken
+		}
+	}
+
+	if !fromCookie {
+		raw = make([]byte, csrfTokenLength)
+		if _, err := rand.Read(raw); err != nil {
+			return r, router.NewHTTPError(http.StatusInternalServerError, "Unable to generate a CSRF token")
+		}
+		cookie := c.cookie
+		cookie.Value = base64.RawURLEncoding.EncodeToString(raw)
+		cookie.Secure = cookie.Secure || GetRemoteClient(r).Scheme == "https"
+		http.SetCookie(w, &cookie)
+	}
+	// the token depends on the cookie, caches must not share it
+	w.Header().Add("Vary", "Cookie")
+
+	return r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, raw)), nil
+}
+
+// Handler protects a single handler
+func (c *CSRFProtection) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		r, err := c.check(w, r)
+		if err != nil {
+			c.failed(w, r, err)
+			return
+		}
+		handler(w, r, p)
+	}
+}
+
+// Global returns a router that protects all the paths, use ExemptPaths or Exempt for webhooks and other
+// routes called by servers
+func (c *CSRFProtection) Global(inner http.Handler) http.Handler {
+	return &csrfRouter{inner: inner, csrf: c}
+}
+
+func (cr *csrfRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r, err := cr.csrf.check(w, r)
+	if err != nil {
+		cr.csrf.failed(w, r, err)
+		return
+	}
+	cr.inner.ServeHTTP(w, r)
+}
+
+//*********************************************************************************************************************
+
+var defaultCSRF = NewCSRF(CSRFConfig{})
+
+// CSRF protects a handler with the default configuration
+func CSRF(handler router.RequestHandler) router.RequestHandler {
+	return defaultCSRF.Handler(handler)
+}
+
+// GlobalCSRF returns a router that protects all the paths with the default configuration
+func GlobalCSRF(router http.Handler) http.Handler {
+	return defaultCSRF.Global(router)
+}
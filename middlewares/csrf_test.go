@@ -0,0 +1,120 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestCSRF(t *testing.T) {
+	csrf := NewCSRF(CSRFConfig{
+		TrustedOrigins: []string{"https://app.example.com"},
+		ExemptPaths:    []string{"/webhooks/*"},
+	})
+
+	var token string
+	rt := router.MakeRouter()
+	rt.GET("/form", func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		token = CSRFToken(r)
+	})
+	rt.POST("/form", printHello)
+	rt.POST("/webhooks/github", printHello)
+	handler := csrf.Global(rt)
+
+	res := runRequest(handler, "GET", "/form", nil)
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "csrf_token" || !cookies[0].HttpOnly || token == "" {
+		t.Fatalf("Token not issued: %v %q", cookies, token)
+	}
+	cookie := "csrf_token=" + cookies[0].Value
+
+	// masked tokens change every request but stay valid
+	first := token
+	if res := runRequest(handler, "GET", "/form", map[string]string{"Cookie": cookie}); token == first || len(res.Result().Cookies()) != 0 {
+		t.Errorf("Token not masked or cookie issued again")
+	}
+
+	post := func(headers map[string]string) int {
+		headers["Cookie"] = cookie
+		return runRequest(handler, "POST", "/form", headers).Code
+	}
+	if code := post(map[string]string{"X-CSRF-Token": token}); code != http.StatusOK {
+		t.Errorf("Valid token rejected: %d", code)
+	}
+	if code := post(map[string]string{"X-CSRF-Token": first, "Origin": "https://app.example.com"}); code != http.StatusOK {
+		t.Errorf("Trusted origin rejected: %d", code)
+	}
+	if code := post(map[string]string{}); code != http.StatusForbidden {
+		t.Errorf("Missing token accepted: %d", code)
+	}
+	if code := post(map[string]string{"X-CSRF-Token": token[1:] + "A"}); code != http.StatusForbidden {
+		t.Errorf("Wrong token accepted: %d", code)
+	}
+	if code := post(map[string]string{"X-CSRF-Token": token, "Origin": "https://evil.com"}); code != http.StatusForbidden {
+		t.Errorf("Cross origin request accepted: %d", code)
+	}
+	if code := post(map[string]string{"X-CSRF-Token": token, "Referer": "https://evil.com/page"}); code != http.StatusForbidden {
+		t.Errorf("Cross origin referer accepted: %d", code)
+	}
+	if code := post(map[string]string{"X-CSRF-Token": token, "Referer": "http://example.com/form"}); code != http.StatusOK {
+		t.Errorf("Same origin referer rejected: %d", code)
+	}
+
+	// form field
+	req := httptest.NewRequest("POST", "/form", strings.NewReader("csrf_token="+token))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cookie", cookie)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Form token rejected: %d", rec.Code)
+	}
+
+	if res := runRequest(handler, "POST", "/webhooks/github", nil); res.Code != http.StatusOK {
+		t.Errorf("Exempt path rejected: %d", res.Code)
+	}
+}
+
+func TestCSRFHandler(t *testing.T) {
+	var reason error
+	csrf := NewCSRF(CSRFConfig{
+		HeaderName: "X-XSRF",
+		Failed: func(w http.ResponseWriter, _ *http.Request, err error) {
+			reason = err
+			w.WriteHeader(http.StatusTeapot)
+		},
+	})
+
+	rt := router.MakeRouter()
+	rt.DELETE("/item", csrf.Handler(printHello))
+	rt.DELETE("/open", printHello)
+
+	if res := runRequest(rt, "DELETE", "/item", nil); res.Code != http.StatusTeapot || reason != ErrCSRFToken {
+		t.Errorf("Custom handler not used: %d %v", res.Code, reason)
+	}
+	if res := runRequest(rt, "DELETE", "/item", map[string]string{"Origin": "http://other.com"}); reason != ErrCSRFOrigin {
+		t.Errorf("Wrong rejection reason: %d %v", res.Code, reason)
+	}
+	if res := runRequest(rt, "DELETE", "/open", nil); res.Code != http.StatusOK {
+		t.Errorf("Route without protection rejected: %d", res.Code)
+	}
+}
@@ -18,60 +18,267 @@ package middlewares
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/rickycorte/pantofola-rest/router"
 )
 
-// Cors adds access-control header to allow cors request to this handler
-func Cors(handler router.RequestHandler) router.RequestHandler {
+// CorsConfig describes the cross origin requests accepted by a handler
+type CorsConfig struct {
+	// AllowedOrigins can contain exact origins (https://example.com), wildcard subdomains (https://*.example.com)
+	// or "*" to allow any origin
+	AllowedOrigins []string
+	// AllowOriginFunc is checked when the origin does not match AllowedOrigins
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods accepted in preflight requests, if empty all the methods supported by the router are allowed
+	AllowedMethods []string
+	// AllowedHeaders accepted in preflight requests, "*" allows any header
+	AllowedHeaders []string
+	// ExposedHeaders are the headers that the browser can read from the responce
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is the number of seconds a preflight can be cached, 0 to not send it
+	MaxAge int
+}
+
+// CorsPolicy is a compiled CorsConfig, Override adds different policies for path prefixes
+type CorsPolicy struct {
+	anyOrigin      bool
+	origins        map[string]bool
+	wildcards      [][2]string // scheme:// + domain suffix
+	originFunc     func(string) bool
+	methods        map[string]bool
+	anyHeader      bool
+	headers        map[string]bool
+	allowMethods   string
+	allowHeaders   string
+	exposedHeaders string
+	credentials    bool
+	maxAge         string
+	preflight      bool
+	overrides      []corsOverride
+}
+
+// a path prefix with a different cors policy
+type corsOverride struct {
+	prefix string
+	cors   *CorsPolicy
+}
+
+type corsRouter struct {
+	inner http.Handler
+	cors  *CorsPolicy
+}
+
+var defaultCorsMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+//*********************************************************************************************************************
+
+// compile the header into a single line string
+func compileHeader(data []string) string {
+	return strings.Join(data, ",")
+}
+
+// NewCors compiles a cors configuration
+func NewCors(config CorsConfig) *CorsPolicy {
+	c := &CorsPolicy{
+		origins:     make(map[string]bool),
+		originFunc:  config.AllowOriginFunc,
+		methods:     make(map[string]bool),
+		headers:     make(map[string]bool),
+		credentials: config.AllowCredentials,
+		preflight:   true,
+	}
+
+	for _, origin := range config.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		if origin == "*" {
+			c.anyOrigin = true
+		} else if i := strings.Index(origin, "://*."); i != -1 {
+			c.wildcards = append(c.wildcards, [2]string{origin[:i+3], origin[i+4:]})
+		} else {
+			c.origins[origin] = true
+		}
+	}
+
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCorsMethods
+	}
+	for _, m := range methods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	c.allowMethods = compileHeader(methods)
+
+	for _, h := range config.AllowedHeaders {
+		if h == "*" {
+			c.anyHeader = true
+		}
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	c.allowHeaders = compileHeader(config.AllowedHeaders)
+	c.exposedHeaders = compileHeader(config.ExposedHeaders)
+
+	if config.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(config.MaxAge)
+	}
+
+	return c
+}
+
+// Override uses a different cors configuration for all the paths that start with prefix (es: /api/public)
+// the longest matching prefix is used, this works only when the cors is used as a global middleware
+func (c *CorsPolicy) Override(prefix string, config CorsConfig) *CorsPolicy {
+	c.overrides = append(c.overrides, corsOverride{prefix: prefix, cors: NewCors(config)})
+	return c
+}
+
+// find the policy for a path
+func (c *CorsPolicy) forPath(path string) *CorsPolicy {
+	best := c
+	bestSize := -1
+	for _, o := range c.overrides {
+		if len(o.prefix) > bestSize && strings.HasPrefix(path, o.prefix) {
+			best = o.cors
+			bestSize = len(o.prefix)
+		}
+	}
+	return best
+}
+
+// check if an origin is allowed
+func (c *CorsPolicy) originAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	lower := strings.ToLower(origin)
+	if c.origins[lower] {
+		return true
+	}
+	for _, w := range c.wildcards {
+		if strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) && len(lower) > len(w[0])+len(w[1]) {
+			return true
+		}
+	}
+
+	return c.originFunc != nil && c.originFunc(origin)
+}
+
+// set the headers shared by preflight and normal requests, returns false if the origin is not allowed
+func (c *CorsPolicy) setOriginHeaders(w http.ResponseWriter, origin string) bool {
+	h := w.Header()
+
+	// the response changes with the origin unless every origin gets the same "*"
+	if !c.anyOrigin || c.credentials {
+		h.Add("Vary", "Origin")
+	}
+
+	if origin == "" || !c.originAllowed(origin) {
+		return false
+	}
+
+	if c.anyOrigin && !c.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		// credentials can't be used with *
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// answer a preflight request
+func (c *CorsPolicy) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	if !c.setOriginHeaders(w, r.Header.Get("Origin")) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !c.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	if requested != "" {
+		for _, header := range strings.Split(requested, ",") {
+			header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+			if header != "" && !c.anyHeader && !c.headers[header] {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	h.Set("Access-Control-Allow-Methods", c.allowMethods)
+	if c.anyHeader && requested != "" {
+		h.Set("Access-Control-Allow-Headers", requested)
+	} else if c.allowHeaders != "" {
+		h.Set("Access-Control-Allow-Headers", c.allowHeaders)
+	}
+	if c.maxAge != "" {
+		h.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent) // no body
+}
 
+// set the headers of a normal (not preflight) request
+func (c *CorsPolicy) setHeaders(w http.ResponseWriter, r *http.Request) {
+	if c.setOriginHeaders(w, r.Header.Get("Origin")) && c.exposedHeaders != "" {
+		w.Header().Set("Access-Control-Expose-Headers", c.exposedHeaders)
+	}
+}
+
+// Handler adds cors headers to a single handler
+// preflight requests never reach a handler because OPTIONS is not routed, use Global to answer them
+func (c *CorsPolicy) Handler(handler router.RequestHandler) router.RequestHandler {
 	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		c.setHeaders(w, r)
 		handler(w, r, p)
 	}
 }
 
-type corsRouter struct {
-	inner     http.Handler
-	preflight bool
-	methods   string
-	headers   string
-	maxAge    int
+// Global returns a router that adds cors headers to every path and answers preflight requests
+func (c *CorsPolicy) Global(inner http.Handler) http.Handler {
+	return &corsRouter{inner: inner, cors: c}
 }
 
 func (lr *corsRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	c := lr.cors.forPath(r.URL.Path)
 
-	if lr.preflight && r.Method == "OPTIONS" {
-		w.Header().Set("Access-Control-Allow-Methods", lr.methods)
-		w.Header().Set("Access-Control-Allow-Headers", lr.headers)
-		w.Header().Set("Access-Control-Max-Age", string(lr.maxAge))
-		w.WriteHeader(204) // no body
+	if c.preflight && r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		c.handlePreflight(w, r)
 		return
 	}
 
+	c.setHeaders(w, r)
 	lr.inner.ServeHTTP(w, r)
 }
 
-// GlobalCors create a router with enable cors for every path
-func GlobalCors(router http.Handler) http.Handler {
-	return &corsRouter{inner: router}
+//*********************************************************************************************************************
+
+var anyOriginCors = &CorsPolicy{anyOrigin: true}
+
+// Cors adds access-control header to allow cors request to this handler
+func Cors(handler router.RequestHandler) router.RequestHandler {
+	return anyOriginCors.Handler(handler)
 }
 
-// compile the header into a single line string
-func compileHeader(data []string) string {
-	compiledHeader := ""
-	for i := 0; data != nil && i < len(data); i++ {
-		compiledHeader += data[i]
-		if i != len(data)-1 {
-			compiledHeader += ","
-		}
-	}
-	return compiledHeader
+// GlobalCors create a router with enable cors for every path
+func GlobalCors(router http.Handler) http.Handler {
+	return anyOriginCors.Global(router)
 }
 
 // GlobalCorsPreflight enables cors requests but also enables preflight OPTIONS requests with custom
 // data passed as parameter
 func GlobalCorsPreflight(router http.Handler, methods, headers []string) http.Handler {
-	return &corsRouter{inner: router, preflight: true, methods: compileHeader(methods), headers: compileHeader(headers), maxAge: 86400}
+	return NewCors(CorsConfig{AllowedOrigins: []string{"*"}, AllowedMethods: methods, AllowedHeaders: headers, MaxAge: 86400}).Global(router)
 }
@@ -0,0 +1,114 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func printHello(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "hello")
+}
+
+// run a request with custom headers and return the recorder
+func runRequest(handler http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestCorsPreflight(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/a", printHello)
+	handler := GlobalCorsPreflight(rt, []string{"GET", "POST"}, []string{"Content-Type"})
+
+	res := runRequest(handler, "OPTIONS", "/a", map[string]string{
+		"Origin": "https://example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "content-type",
+	})
+	if res.Code != 204 || res.Header().Get("Access-Control-Max-Age") != "86400" ||
+		res.Header().Get("Access-Control-Allow-Origin") != "*" || res.Header().Get("Access-Control-Allow-Methods") != "GET,POST" {
+		t.Errorf("Unexpected preflight: %d %v", res.Code, res.Header())
+	}
+
+	res = runRequest(handler, "OPTIONS", "/a", map[string]string{"Origin": "https://example.com", "Access-Control-Request-Method": "DELETE"})
+	if res.Code != 403 {
+		t.Errorf("Preflight with wrong method should fail, got: %d", res.Code)
+	}
+
+	res = runRequest(handler, "OPTIONS", "/a", map[string]string{
+		"Origin": "https://example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Secret",
+	})
+	if res.Code != 403 {
+		t.Errorf("Preflight with wrong header should fail, got: %d", res.Code)
+	}
+}
+
+func TestCorsOrigins(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/a", printHello)
+	rt.GET("/public/a", printHello)
+
+	cors := NewCors(CorsConfig{
+		AllowedOrigins:   []string{"https://example.com", "https://*.example.org"},
+		AllowOriginFunc:  func(origin string) bool { return origin == "http://localhost:8080" },
+		AllowCredentials: true,
+		ExposedHeaders:   []string{"X-Total"},
+	}).Override("/public", CorsConfig{AllowedOrigins: []string{"*"}})
+	handler := cors.Global(rt)
+
+	cases := []struct {
+		path, origin, allowed string
+	}{
+		{"/a", "https://example.com", "https://example.com"},
+		{"/a", "https://api.example.org", "https://api.example.org"},
+		{"/a", "https://example.org", ""},
+		{"/a", "https://evil.com", ""},
+		{"/a", "http://localhost:8080", "http://localhost:8080"},
+		{"/public/a", "https://evil.com", "*"},
+	}
+
+	for _, c := range cases {
+		res := runRequest(handler, "GET", c.path, map[string]string{"Origin": c.origin})
+		if res.Code != 200 || res.Header().Get("Access-Control-Allow-Origin") != c.allowed {
+			t.Errorf("Unexpected allowed origin for %s %s: %s", c.path, c.origin, res.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if c.path == "/a" && (res.Header().Get("Vary") != "Origin") {
+			t.Errorf("Missing Vary header for %s", c.origin)
+		}
+		if c.allowed != "" && c.path == "/a" &&
+			(res.Header().Get("Access-Control-Allow-Credentials") != "true" || res.Header().Get("Access-Control-Expose-Headers") != "X-Total") {
+			t.Errorf("Missing credentials headers for %s: %v", c.origin, res.Header())
+		}
+	}
+
+	// per handler policy
+	rt.GET("/b", cors.Handler(printHello))
+	res := runRequest(rt, "GET", "/b", map[string]string{"Origin": "https://example.com"})
+	if res.Header().Get("Access-Control-Allow-Origin") != "https://example.com" {
+		t.Errorf("Per handler cors not applied: %v", res.Header())
+	}
+}
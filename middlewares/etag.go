@@ -0,0 +1,295 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// ETagConfig describes how etags are computed and checked
+type ETagConfig struct {
+	// Weak sends weak etags (W/"..."), use it when the same data can be encoded in different ways
+	Weak bool
+	// CurrentETag returns the etag of the resource a PUT, PATCH or DELETE request is going to change
+	// it is used to check If-Match and If-None-Match before the handler runs, return "" if the resource does not exist
+	// if nil the preconditions of these requests are not checked
+	CurrentETag func(r *http.Request, p *router.ParameterList) (string, error)
+}
+
+// ETagger adds etags to GET and HEAD responces and answers conditional requests with 304 or 412
+type ETagger struct {
+	weak        bool
+	currentETag func(*http.Request, *router.ParameterList) (string, error)
+}
+
+// writer that keeps the responce until the etag is known
+type etagWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         []byte
+	passthrough bool // the handler flushed, the responce is already sent
+}
+
+type etagRouter struct {
+	inner http.Handler
+	e     *ETagger
+}
+
+var defaultETagger = NewETagger(ETagConfig{})
+
+//*********************************************************************************************************************
+// etag helpers
+
+// remove the weak prefix of an etag
+func opaqueTag(etag string) string {
+	return strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+}
+
+// check if an etag is in a If-Match or If-None-Match list
+// strong comparison is used by If-Match, weak comparison by If-None-Match (RFC 7232)
+func etagInList(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if opaqueTag(candidate) == opaqueTag(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ComputeETag returns the etag of a body
+func ComputeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+//*********************************************************************************************************************
+// ETagger
+
+// NewETagger creates an etag middleware
+func NewETagger(config ETagConfig) *ETagger {
+	return &ETagger{weak: config.Weak, currentETag: config.CurrentETag}
+}
+
+// check the preconditions of a request that changes a resource, returns false if the responce is already sent
+func (e *ETagger) checkPreconditions(w http.ResponseWriter, r *http.Request, p *router.ParameterList) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if e.currentETag == nil || (ifMatch == "" && ifNoneMatch == "") {
+		return true
+	}
+
+	current, err := e.currentETag(r, p)
+	if err != nil {
+		router.RenderProblem(w, r, router.ProblemFromError(err, r))
+		return false
+	}
+
+	if (ifMatch != "" && !etagInList(ifMatch, current, true)) || (ifNoneMatch != "" && etagInList(ifNoneMatch, current, false)) {
+		router.RenderProblem(w, r, &router.Problem{
+			Type:     "about:blank",
+			Title:    http.StatusText(http.StatusPreconditionFailed),
+			Status:   http.StatusPreconditionFailed,
+			Detail:   "The resource was changed by another request",
+			Instance: r.URL.Path,
+		})
+		return false
+	}
+	return true
+}
+
+// check if the client copy is still valid
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagInList(inm, h.Get("ETag"), false)
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(ims)
+}
+
+// run a handler with the etag logic
+func (e *ETagger) serve(w http.ResponseWriter, r *http.Request, p *router.ParameterList, next func(http.ResponseWriter)) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "PUT", "PATCH", "DELETE":
+		if e.checkPreconditions(w, r, p) {
+			next(w)
+		}
+		return
+	default:
+		next(w)
+		return
+	}
+
+	ew := &etagWriter{ResponseWriter: w}
+	next(ew)
+	if ew.passthrough {
+		return
+	}
+	if !ew.wroteHeader {
+		ew.status = http.StatusOK
+	}
+
+	h := w.Header()
+	if ew.status != http.StatusOK {
+		w.WriteHeader(ew.status)
+		w.Write(ew.buf)
+		return
+	}
+
+	if h.Get("ETag") == "" {
+		h.Set("ETag", ComputeETag(ew.buf, e.weak))
+	}
+
+	if notModified(r, h) {
+		// 304 has no body and must not describe one
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if h.Get("Content-Length") == "" && r.Method == "GET" {
+		h.Set("Content-Length", strconv.Itoa(len(ew.buf)))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(ew.buf)
+}
+
+// Handler adds etags to a single handler
+func (e *ETagger) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		e.serve(w, r, p, func(ew http.ResponseWriter) {
+			handler(ew, r, p)
+		})
+	}
+}
+
+// Global returns a router that adds etags to all the responces
+// CurrentETag receives nil parameters because routes are not matched yet
+func (e *ETagger) Global(inner http.Handler) http.Handler {
+	return &etagRouter{inner: inner, e: e}
+}
+
+func (er *etagRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	er.e.serve(w, r, nil, func(ew http.ResponseWriter) {
+		er.inner.ServeHTTP(ew, r)
+	})
+}
+
+//*********************************************************************************************************************
+// etagWriter
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.status = code
+	ew.wroteHeader = true
+	if ew.passthrough {
+		ew.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.passthrough {
+		return ew.ResponseWriter.Write(b)
+	}
+	ew.buf = append(ew.buf, b...)
+	return len(b), nil
+}
+
+// Flush implements http.Flusher, a streamed responce is sent as is without etag
+func (ew *etagWriter) Flush() {
+	if !ew.passthrough {
+		ew.passthrough = true
+		if ew.wroteHeader {
+			ew.ResponseWriter.WriteHeader(ew.status)
+		}
+		if len(ew.buf) > 0 {
+			ew.ResponseWriter.Write(ew.buf)
+			ew.buf = nil
+		}
+	}
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, the buffered body is dropped
+func (ew *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := ew.ResponseWriter.(http.Hijacker); ok {
+		ew.passthrough = true
+		ew.buf = nil
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (ew *etagWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+//*********************************************************************************************************************
+
+// ETag adds etags and conditional GET support to a handler
+func ETag(handler router.RequestHandler) router.RequestHandler {
+	return defaultETagger.Handler(handler)
+}
+
+// GlobalETag returns a router that adds etags and conditional GET support to all the responces
+func GlobalETag(router http.Handler) http.Handler {
+	return defaultETagger.Global(router)
+}
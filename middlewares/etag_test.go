@@ -0,0 +1,122 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestETag(t *testing.T) {
+	modified := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	rt := router.MakeRouter()
+	rt.GET("/hello", ETag(printHello))
+	rt.GET("/dated", func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Write([]byte("dated"))
+	})
+	rt.GET("/missing", func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := NewETagger(ETagConfig{Weak: true}).Global(rt)
+
+	res := runRequest(rt, "GET", "/hello", nil)
+	etag := res.Header().Get("ETag")
+	if res.Code != 200 || len(etag) != 34 || etag[0] != '"' || res.Body.String() != "hello" {
+		t.Fatalf("Unexpected responce: %d %q %q", res.Code, etag, res.Body.String())
+	}
+	res = runRequest(rt, "GET", "/hello", map[string]string{"If-None-Match": `"other", W/` + etag})
+	if res.Code != http.StatusNotModified || res.Body.Len() != 0 || res.Header().Get("ETag") != etag {
+		t.Errorf("Matching etag should give 304: %d", res.Code)
+	}
+
+	res = runRequest(handler, "GET", "/dated", nil)
+	if weak := res.Header().Get("ETag"); weak[:2] != "W/" {
+		t.Errorf("Weak etag expected: %q", weak)
+	}
+	ims := map[string]string{"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat)}
+	if res = runRequest(handler, "GET", "/dated", ims); res.Code != http.StatusNotModified {
+		t.Errorf("Not modified resource should give 304: %d", res.Code)
+	}
+	ims["If-Modified-Since"] = modified.Add(-time.Hour).Format(http.TimeFormat)
+	if res = runRequest(handler, "GET", "/dated", ims); res.Code != 200 || res.Body.String() != "dated" {
+		t.Errorf("Modified resource should give 200: %d", res.Code)
+	}
+	if res = runRequest(handler, "GET", "/missing", nil); res.Code != 404 || res.Header().Get("ETag") != "" {
+		t.Errorf("Errors should not get an etag: %d %v", res.Code, res.Header())
+	}
+}
+
+func TestETagPreconditions(t *testing.T) {
+	version := `"v2"`
+	updates := 0
+	e := NewETagger(ETagConfig{CurrentETag: func(_ *http.Request, p *router.ParameterList) (string, error) {
+		if p.Get("id") != "1" {
+			return "", nil
+		}
+		return version, nil
+	}})
+
+	rt := router.MakeRouter()
+	rt.PUT("/items/:id", e.Handler(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		updates++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	if res := runRequest(rt, "PUT", "/items/1", map[string]string{"If-Match": `"v1"`}); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("Stale etag should give 412: %d", res.Code)
+	}
+	if res := runRequest(rt, "PUT", "/items/1", map[string]string{"If-Match": `W/"v2"`}); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match must use strong comparison: %d", res.Code)
+	}
+	if res := runRequest(rt, "PUT", "/items/1", map[string]string{"If-Match": `"v1", "v2"`}); res.Code != http.StatusNoContent {
+		t.Errorf("Current etag should be accepted: %d", res.Code)
+	}
+	if res := runRequest(rt, "PUT", "/items/2", map[string]string{"If-Match": "*"}); res.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match * on a missing resource should give 412: %d", res.Code)
+	}
+	if res := runRequest(rt, "PUT", "/items/2", map[string]string{"If-None-Match": "*"}); res.Code != http.StatusNoContent {
+		t.Errorf("If-None-Match * should allow creation: %d", res.Code)
+	}
+	if res := runRequest(rt, "PUT", "/items/1", nil); res.Code != http.StatusNoContent || updates != 3 {
+		t.Errorf("Unconditional request should run: %d %d", res.Code, updates)
+	}
+}
+
+func TestETagKeepsWriterInterfaces(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/socket", ETag(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Write([]byte("buffered"))
+		if _, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok {
+			t.Errorf("Writer can't be unwrapped")
+		}
+		if _, _, err := w.(http.Hijacker).Hijack(); err != nil {
+			t.Errorf("Hijack failed: %v", err)
+		}
+	}))
+
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rt.ServeHTTP(recorder, httptest.NewRequest("GET", "/socket", nil))
+	if !recorder.hijacked || recorder.Body.Len() != 0 || recorder.Header().Get("ETag") != "" {
+		t.Errorf("Hijacked responce was written: %q %v", recorder.Body.String(), recorder.Header())
+	}
+}
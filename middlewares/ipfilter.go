@@ -0,0 +1,205 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/rickycorte/pantofola-rest/cascade"
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// IPRule allows or denies an ip or CIDR range (es: 10.8.0.0/16, fd00::/8)
+type IPRule struct {
+	Allow   bool
+	Network string
+}
+
+// IPFilterConfig describes an ip filter
+type IPFilterConfig struct {
+	// Rules are checked in order, the first one that matches the client decides
+	Rules []IPRule
+	// DefaultAllow is used when no rule matches, by default unmatched clients are denied
+	DefaultAllow bool
+	// Resolver finds the client ip when no ProxyResolver was used before the filter
+	Resolver *ProxyResolver
+	// Denied writes the responce of rejected clients, default is a 403 problem
+	Denied router.RequestHandler
+}
+
+// IPFilter allows or denies requests by client ip, rules can be reloaded at runtime
+type IPFilter struct {
+	mutex        sync.RWMutex
+	rules        []compiledIPRule
+	defaultAllow bool
+	resolver     *ProxyResolver
+	denied       router.RequestHandler
+}
+
+type compiledIPRule struct {
+	allow   bool
+	network *net.IPNet
+}
+
+type ipFilterRouter struct {
+	inner  http.Handler
+	filter *IPFilter
+}
+
+// AllowIP returns a rule that allows an ip or CIDR range
+func AllowIP(network string) IPRule {
+	return IPRule{Allow: true, Network: network}
+}
+
+// DenyIP returns a rule that denies an ip or CIDR range
+func DenyIP(network string) IPRule {
+	return IPRule{Allow: false, Network: network}
+}
+
+// default responce of rejected clients
+func defaultIPDenied(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+	router.RenderProblem(w, r, &router.Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(http.StatusForbidden),
+		Status:   http.StatusForbidden,
+		Instance: r.URL.Path,
+	})
+}
+
+// parse all the rules, nothing is returned if one is not valid
+func compileIPRules(rules []IPRule) ([]compiledIPRule, error) {
+	compiled := make([]compiledIPRule, 0, len(rules))
+	for _, rule := range rules {
+		network, err := parseIPNet(rule.Network)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, compiledIPRule{allow: rule.Allow, network: network})
+	}
+	return compiled, nil
+}
+
+// NewIPFilter creates an ip filter, it panics if a rule is not a valid ip or CIDR range
+func NewIPFilter(config IPFilterConfig) *IPFilter {
+	f := &IPFilter{
+		defaultAllow: config.DefaultAllow,
+		resolver:     config.Resolver,
+		denied:       config.Denied,
+	}
+	if err := f.Reload(config.Rules); err != nil {
+		panic("Invalid ip filter rule: " + err.Error())
+	}
+	if f.denied == nil {
+		f.denied = defaultIPDenied
+	}
+	return f
+}
+
+// Reload replaces the rules, the current ones are kept if one of the new rules is not valid
+// it's safe to call while requests are served
+func (f *IPFilter) Reload(rules []IPRule) error {
+	compiled, err := compileIPRules(rules)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	f.rules = compiled
+	f.mutex.Unlock()
+	return nil
+}
+
+// Allowed checks if an ip passes the filter
+func (f *IPFilter) Allowed(value string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	for _, rule := range f.rules {
+		if rule.network.Contains(ip) {
+			return rule.allow
+		}
+	}
+	return f.defaultAllow
+}
+
+// check the client of a request
+func (f *IPFilter) allowRequest(r *http.Request) bool {
+	if _, resolved := r.Context().Value(remoteClientKey{}).(RemoteClient); !resolved && f.resolver != nil {
+		return f.Allowed(f.resolver.Resolve(r).IP)
+	}
+	return f.Allowed(ClientIP(r))
+}
+
+// Handler filters the clients of a single handler
+func (f *IPFilter) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		if !f.allowRequest(r) {
+			f.denied(w, r, p)
+			return
+		}
+		handler(w, r, p)
+	}
+}
+
+// Global returns a router that filters all the clients
+func (f *IPFilter) Global(inner http.Handler) http.Handler {
+	return &ipFilterRouter{inner: inner, filter: f}
+}
+
+// Group filters the clients of a sub router that is mounted in a cascade router
+func (f *IPFilter) Group(inner cascade.Handler) cascade.Handler {
+	return &ipFilterRouter{inner: inner, filter: f}
+}
+
+func (fr *ipFilterRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !fr.filter.allowRequest(r) {
+		fr.filter.denied(w, r, nil)
+		return
+	}
+	fr.inner.ServeHTTP(w, r)
+}
+
+// UsePrefix forwards the prefix to the inner router so a filtered router can be used in a cascade
+func (fr *ipFilterRouter) UsePrefix(prefix string) {
+	if ch, ok := fr.inner.(cascade.Handler); ok {
+		ch.UsePrefix(prefix)
+	}
+}
+
+// SetLogger forwards the logger of a cascade to the inner router
+func (fr *ipFilterRouter) SetLogger(logger router.Logger) {
+	if ls, ok := fr.inner.(interface{ SetLogger(router.Logger) }); ok {
+		ls.SetLogger(logger)
+	}
+}
+
+//*********************************************************************************************************************
+
+// GlobalAllowIPs returns a router reachable only from the given ips or CIDR ranges
+func GlobalAllowIPs(router http.Handler, networks ...string) http.Handler {
+	rules := make([]IPRule, 0, len(networks))
+	for _, network := range networks {
+		rules = append(rules, AllowIP(network))
+	}
+	return NewIPFilter(IPFilterConfig{Rules: rules}).Global(router)
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/cascade"
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestIPFilterRules(t *testing.T) {
+	filter := NewIPFilter(IPFilterConfig{Rules: []IPRule{
+		DenyIP("10.8.0.66"),
+		AllowIP("10.8.0.0/16"),
+		AllowIP("fd00:1::/32"),
+	}})
+
+	tests := map[string]bool{
+		"10.8.1.2":        true,
+		"10.8.0.66":       false,
+		"10.9.0.1":        false,
+		"fd00:1::5":       true,
+		"fd00:2::5":       false,
+		"not an ip":       false,
+		"127.0.0.1":       false,
+		"::ffff:10.8.3.3": true,
+	}
+	for ip, allowed := range tests {
+		if filter.Allowed(ip) != allowed {
+			t.Errorf("Wrong result for %s, expected %v", ip, allowed)
+		}
+	}
+
+	if err := filter.Reload([]IPRule{AllowIP("10.9.0.0/16"), DenyIP("bad")}); err == nil {
+		t.Errorf("Invalid rule accepted")
+	}
+	if !filter.Allowed("10.8.1.2") {
+		t.Errorf("Rules changed by a failed reload")
+	}
+	if err := filter.Reload([]IPRule{AllowIP("10.9.0.0/16")}); err != nil || filter.Allowed("10.8.1.2") || !filter.Allowed("10.9.0.1") {
+		t.Errorf("Rules not reloaded: %v", err)
+	}
+}
+
+func TestIPFilterGroup(t *testing.T) {
+	filter := NewIPFilter(IPFilterConfig{
+		Rules:    []IPRule{AllowIP("10.8.0.0/16")},
+		Resolver: NewProxyResolver(ProxyConfig{TrustedProxies: []string{"192.168.0.1"}}),
+		Denied: func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+			w.WriteHeader(http.StatusNotFound)
+		},
+	})
+
+	admin := router.MakeRouter()
+	admin.GET("/stats", printHello)
+	public := router.MakeRouter()
+	public.GET("/stats", printHello)
+
+	cr := cascade.MakeCascade()
+	cr.Set("", public)
+	cr.Set("/admin", filter.Group(admin))
+
+	send := func(path, remote, forwarded string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remote
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		rec := httptest.NewRecorder()
+		cr.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("/stats", "1.1.1.1:80", ""); code != http.StatusOK {
+		t.Errorf("Public route filtered: %d", code)
+	}
+	if code := send("/admin/stats", "1.1.1.1:80", ""); code != http.StatusNotFound {
+		t.Errorf("Custom denied handler not used: %d", code)
+	}
+	if code := send("/admin/stats", "10.8.0.5:80", ""); code != http.StatusOK {
+		t.Errorf("Allowed client rejected: %d", code)
+	}
+	if code := send("/admin/stats", "192.168.0.1:80", "10.8.0.5"); code != http.StatusOK {
+		t.Errorf("Client behind trusted proxy rejected: %d", code)
+	}
+	if code := send("/admin/stats", "1.1.1.1:80", "10.8.0.5"); code != http.StatusNotFound {
+		t.Errorf("Forwarded header trusted from untrusted client: %d", code)
+	}
+
+	if res := runRequest(GlobalAllowIPs(public, "10.0.0.0/8"), "GET", "/stats", nil); res.Code != http.StatusForbidden {
+		t.Errorf("Global filter not applied: %d", res.Code)
+	}
+}
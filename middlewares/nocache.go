@@ -17,31 +17,270 @@
 package middlewares
 
 import (
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rickycorte/pantofola-rest/router"
 )
 
-// NoCache sets the header Cache-Control to no-cache for the path
-func NoCache(handler router.RequestHandler) router.RequestHandler {
+// CachePolicy builds the Cache-Control, Expires and Surrogate-Control headers of responces
+// headers already set by the handler are never replaced
+type CachePolicy struct {
+	public         bool
+	private        bool
+	noCache        bool
+	noStore        bool
+	immutable      bool
+	mustRevalidate bool
+	maxAge         time.Duration
+	sharedMaxAge   time.Duration
+	swr            time.Duration
+	expires        time.Duration
+	surrogateAge   time.Duration
+	hasMaxAge      bool
+	hasSharedAge   bool
+	hasExpires     bool
+	hasSurrogate   bool
+	rules          []cacheRule
+}
+
+// a policy used for some responces instead of the main one
+type cacheRule struct {
+	minStatus   int
+	maxStatus   int
+	contentType string
+	policy      *CachePolicy
+}
+
+// writer that sets the cache headers once the status and the content type are known
+type cachePolicyWriter struct {
+	*router.ResponseWriter
+	policy  *CachePolicy
+	applied bool
+}
+
+type cachePolicyRouter struct {
+	inner  http.Handler
+	policy *CachePolicy
+}
+
+var noCachePolicy = NewCachePolicy().NoCache()
+
+//*********************************************************************************************************************
+// CachePolicy builder
+
+// NewCachePolicy creates an empty policy, build it with the chainable methods es: NewCachePolicy().Public().MaxAge(time.Hour)
+func NewCachePolicy() *CachePolicy {
+	return &CachePolicy{}
+}
+
+// Public allows shared caches (CDN, proxies) to store the responce
+func (cp *CachePolicy) Public() *CachePolicy {
+	cp.public = true
+	return cp
+}
+
+// Private allows only the browser to store the responce
+func (cp *CachePolicy) Private() *CachePolicy {
+	cp.private = true
+	return cp
+}
+
+// NoCache requires caches to revalidate the responce before every use
+func (cp *CachePolicy) NoCache() *CachePolicy {
+	cp.noCache = true
+	return cp
+}
+
+// NoStore forbids any cache to store the responce
+func (cp *CachePolicy) NoStore() *CachePolicy {
+	cp.noStore = true
+	return cp
+}
+
+// Immutable tells browsers the responce never changes while fresh
+func (cp *CachePolicy) Immutable() *CachePolicy {
+	cp.immutable = true
+	return cp
+}
+
+// MustRevalidate forbids caches to use the responce once stale
+func (cp *CachePolicy) MustRevalidate() *CachePolicy {
+	cp.mustRevalidate = true
+	return cp
+}
+
+// MaxAge sets how long the responce is fresh
+func (cp *CachePolicy) MaxAge(age time.Duration) *CachePolicy {
+	cp.maxAge = age
+	cp.hasMaxAge = true
+	return cp
+}
+
+// SharedMaxAge sets how long the responce is fresh for shared caches (s-maxage)
+func (cp *CachePolicy) SharedMaxAge(age time.Duration) *CachePolicy {
+	cp.sharedMaxAge = age
+	cp.hasSharedAge = true
+	return cp
+}
+
+// StaleWhileRevalidate sets how long a stale responce can be used while a new one is fetched
+func (cp *CachePolicy) StaleWhileRevalidate(age time.Duration) *CachePolicy {
+	cp.swr = age
+	return cp
+}
+
+// Expires sends an Expires header set to now + age for old HTTP/1.0 caches
+func (cp *CachePolicy) Expires(age time.Duration) *CachePolicy {
+	cp.expires = age
+	cp.hasExpires = true
+	return cp
+}
+
+// SurrogateMaxAge sends Surrogate-Control: max-age for CDNs, browsers ignore it
+func (cp *CachePolicy) SurrogateMaxAge(age time.Duration) *CachePolicy {
+	cp.surrogateAge = age
+	cp.hasSurrogate = true
+	return cp
+}
+
+// ForStatus uses another policy for responces with a status between min and max (included)
+// es: ForStatus(500, 599, NewCachePolicy().NoStore()) never caches server errors
+// rules are checked in the order they are added
+func (cp *CachePolicy) ForStatus(min, max int, policy *CachePolicy) *CachePolicy {
+	cp.rules = append(cp.rules, cacheRule{minStatus: min, maxStatus: max, policy: policy})
+	return cp
+}
 
+// ForContentType uses another policy for responces whose content type starts with prefix (es: image/)
+func (cp *CachePolicy) ForContentType(prefix string, policy *CachePolicy) *CachePolicy {
+	cp.rules = append(cp.rules, cacheRule{contentType: prefix, policy: policy})
+	return cp
+}
+
+// seconds as used by cache directives
+func ageSeconds(age time.Duration) string {
+	return strconv.FormatInt(int64(age/time.Second), 10)
+}
+
+// String returns the Cache-Control value of the policy (rules are not included)
+func (cp *CachePolicy) String() string {
+	var directives []string
+	add := func(ok bool, directive string) {
+		if ok {
+			directives = append(directives, directive)
+		}
+	}
+
+	add(cp.public, "public")
+	add(cp.private, "private")
+	add(cp.noCache, "no-cache")
+	add(cp.noStore, "no-store")
+	add(cp.hasMaxAge, "max-age="+ageSeconds(cp.maxAge))
+	add(cp.hasSharedAge, "s-maxage="+ageSeconds(cp.sharedMaxAge))
+	add(cp.swr > 0, "stale-while-revalidate="+ageSeconds(cp.swr))
+	add(cp.mustRevalidate, "must-revalidate")
+	add(cp.immutable, "immutable")
+	return strings.Join(directives, ", ")
+}
+
+// find the policy of a responce
+func (cp *CachePolicy) selectPolicy(status int, contentType string) *CachePolicy {
+	for _, rule := range cp.rules {
+		if rule.contentType != "" {
+			if strings.HasPrefix(contentType, rule.contentType) {
+				return rule.policy.selectPolicy(status, contentType)
+			}
+		} else if status >= rule.minStatus && status <= rule.maxStatus {
+			return rule.policy.selectPolicy(status, contentType)
+		}
+	}
+	return cp
+}
+
+// set the headers of the policy that are not already set
+func (cp *CachePolicy) apply(h http.Header, status int) {
+	policy := cp.selectPolicy(status, h.Get("Content-Type"))
+
+	if value := policy.String(); value != "" && h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", value)
+	}
+	if policy.hasExpires && h.Get("Expires") == "" {
+		h.Set("Expires", time.Now().Add(policy.expires).UTC().Format(http.TimeFormat))
+	}
+	if policy.hasSurrogate && h.Get("Surrogate-Control") == "" {
+		h.Set("Surrogate-Control", "max-age="+ageSeconds(policy.surrogateAge))
+	}
+}
+
+// Handler applies the policy to a single handler
+func (cp *CachePolicy) Handler(handler router.RequestHandler) router.RequestHandler {
 	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
-		w.Header().Set("Cache-Control", "no-cache")
-		handler(w, r, p)
+		cw := &cachePolicyWriter{ResponseWriter: router.WrapResponseWriter(w), policy: cp}
+		handler(cw, r, p)
+		cw.finish()
 	}
 }
 
-type noCacheRouter struct {
-	inner http.Handler
+// Global returns a router that applies the policy to all the responces
+func (cp *CachePolicy) Global(inner http.Handler) http.Handler {
+	return &cachePolicyRouter{inner: inner, policy: cp}
 }
 
-func (lr *noCacheRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Cache-Control", "no-cache")
-	lr.inner.ServeHTTP(w, r)
+func (cr *cachePolicyRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cw := &cachePolicyWriter{ResponseWriter: router.WrapResponseWriter(w), policy: cr.policy}
+	cr.inner.ServeHTTP(cw, r)
+	cw.finish()
+}
+
+//*********************************************************************************************************************
+// cachePolicyWriter
+
+func (cw *cachePolicyWriter) WriteHeader(code int) {
+	cw.apply(code)
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cachePolicyWriter) Write(b []byte) (int, error) {
+	cw.apply(http.StatusOK)
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush and ReadFrom of the wrapper would start the responce without the policy headers
+func (cw *cachePolicyWriter) Flush() {
+	cw.apply(http.StatusOK)
+	cw.ResponseWriter.Flush()
+}
+
+func (cw *cachePolicyWriter) ReadFrom(src io.Reader) (int64, error) {
+	cw.apply(http.StatusOK)
+	return cw.ResponseWriter.ReadFrom(src)
+}
+
+// set the policy headers once, before the responce is started
+func (cw *cachePolicyWriter) apply(status int) {
+	if !cw.applied && !cw.HeaderWritten() {
+		cw.applied = true
+		cw.policy.apply(cw.Header(), status)
+	}
+}
+
+// set the headers of handlers that wrote nothing, the server sends them with an empty 200
+func (cw *cachePolicyWriter) finish() {
+	cw.apply(http.StatusOK)
+}
+
+//*********************************************************************************************************************
+
+// NoCache sets the header Cache-Control to no-cache for the path
+func NoCache(handler router.RequestHandler) router.RequestHandler {
+	return noCachePolicy.Handler(handler)
 }
 
 // GlobalNoCache returs a router with Cache-Control header set to no-cache for all the paths
 func GlobalNoCache(router http.Handler) http.Handler {
-
-	return &noCacheRouter{inner: router}
+	return noCachePolicy.Global(router)
 }
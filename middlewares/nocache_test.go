@@ -0,0 +1,88 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestCachePolicy(t *testing.T) {
+	policy := NewCachePolicy().Public().MaxAge(time.Hour).StaleWhileRevalidate(time.Minute).
+		SurrogateMaxAge(24*time.Hour).Expires(time.Hour).
+		ForStatus(500, 599, NewCachePolicy().NoStore()).
+		ForContentType("image/", NewCachePolicy().Public().MaxAge(365*24*time.Hour).Immutable())
+
+	rt := router.MakeRouter()
+	rt.GET("/data", printHello)
+	rt.GET("/fail", func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	rt.GET("/logo", func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	rt.GET("/custom", func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Header().Set("Cache-Control", "private, max-age=10")
+	})
+	rt.GET("/nocache", NoCache(printHello))
+	handler := policy.Global(rt)
+
+	res := runRequest(handler, "GET", "/data", nil)
+	if res.Header().Get("Cache-Control") != "public, max-age=3600, stale-while-revalidate=60" ||
+		res.Header().Get("Surrogate-Control") != "max-age=86400" || res.Header().Get("Expires") == "" {
+		t.Errorf("Unexpected headers: %v", res.Header())
+	}
+	if res = runRequest(handler, "GET", "/fail", nil); res.Header().Get("Cache-Control") != "no-store" || res.Header().Get("Expires") != "" {
+		t.Errorf("Server errors should not be cached: %v", res.Header())
+	}
+	if res = runRequest(handler, "GET", "/logo", nil); res.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Errorf("Content type rule not used: %v", res.Header())
+	}
+	if res = runRequest(handler, "GET", "/custom", nil); res.Header().Get("Cache-Control") != "private, max-age=10" {
+		t.Errorf("Handler header replaced: %v", res.Header())
+	}
+	if res = runRequest(handler, "GET", "/nocache", nil); res.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Route policy should win over the global one: %v", res.Header())
+	}
+}
+
+func TestCachePolicyKeepsWriterInterfaces(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/stream", NoCache(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		if _, ok := w.(http.Pusher); !ok {
+			t.Errorf("Writer is not a pusher")
+		}
+		if _, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok {
+			t.Errorf("Writer can't be unwrapped")
+		}
+		w.(http.Flusher).Flush()
+		w.(io.ReaderFrom).ReadFrom(strings.NewReader("data"))
+	}))
+
+	recorder := httptest.NewRecorder()
+	rt.ServeHTTP(recorder, httptest.NewRequest("GET", "/stream", nil))
+	if !recorder.Flushed || recorder.Body.String() != "data" || recorder.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Policy not applied to a flushed responce: %v", recorder.Header())
+	}
+}
@@ -0,0 +1,291 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// RateLimit is the number of requests allowed in a period, Burst is how many requests can be done at once
+// (default is Requests)
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// Valid checks that the limit allows at least one request per nanosecond of period, stores can't compute
+// an emission interval for faster limits
+func (l RateLimit) Valid() bool {
+	return l.Requests > 0 && l.Period > 0 && l.Period/time.Duration(l.Requests) > 0
+}
+
+// RateLimitResult is the state of a key after a request
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration // time until the key is back to a full burst
+	RetryAfter time.Duration // time until the next request is allowed, 0 if allowed
+}
+
+// RateLimitStore keeps the state of all the rate limited keys
+type RateLimitStore interface {
+	// Take consumes a request for a key if the limit allows it, limits given by a RateLimiter are always Valid
+	Take(key string, limit RateLimit, now time.Time) RateLimitResult
+}
+
+// RateLimitKeyFunc extracts the rate limit key of a request, an empty key skips the limit
+// p is nil when the limiter is used as global middleware
+type RateLimitKeyFunc func(r *http.Request, p *router.ParameterList) string
+
+// RateLimitConfig describes a rate limiter
+type RateLimitConfig struct {
+	Limit RateLimit
+	// Key of the request, default is the client ip
+	Key RateLimitKeyFunc
+	// Store of the limits, default is an in memory store
+	Store RateLimitStore
+	// PerRoute adds the matched route pattern to the key so every route has its own limit
+	// it works only with Handler, global middlewares run before the route is matched
+	PerRoute bool
+	// LimitReached writes the responce when the limit is reached, default is a 429 problem
+	LimitReached router.RequestHandler
+}
+
+// RateLimiter rejects requests of a key over its limit and reports the remaining quota in RateLimit-* headers
+type RateLimiter struct {
+	limit        RateLimit
+	key          RateLimitKeyFunc
+	store        RateLimitStore
+	perRoute     bool
+	limitReached router.RequestHandler
+}
+
+// MemoryRateLimitStore is an in memory GCRA store split in shards to reduce lock contention
+// keys are removed once they are back to a full burst
+type MemoryRateLimitStore struct {
+	shards []rateLimitShard
+}
+
+type rateLimitShard struct {
+	mutex     sync.Mutex
+	tats      map[string]time.Time // theoretical arrival time of the next request
+	lastSweep time.Time
+}
+
+type rateLimitRouter struct {
+	inner   http.Handler
+	limiter *RateLimiter
+}
+
+const rateLimitShards = 32
+const errInvalidRateLimit = "Rate limit requires a positive number of requests and a period of at least one nanosecond per request"
+const errPerRouteGlobal = "Per route rate limits can't be used as global middleware, the route is matched after the limit"
+const rateLimitSweepInterval = time.Minute
+
+//*********************************************************************************************************************
+// keys
+
+// KeyByIP uses the ip of the client as key, resolved by a ProxyResolver if one is used
+func KeyByIP() RateLimitKeyFunc {
+	return func(r *http.Request, _ *router.ParameterList) string {
+		return ClientIP(r)
+	}
+}
+
+// KeyByHeader uses the value of a header as key (es: X-API-Key)
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request, _ *router.ParameterList) string {
+		return r.Header.Get(name)
+	}
+}
+
+// KeyByParam uses a route parameter as key, works only on single handlers
+func KeyByParam(name string) RateLimitKeyFunc {
+	return func(_ *http.Request, p *router.ParameterList) string {
+		if p == nil {
+			return ""
+		}
+		return p.Get(name)
+	}
+}
+
+//*********************************************************************************************************************
+// MemoryRateLimitStore
+
+// NewMemoryRateLimitStore creates an empty in memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{shards: make([]rateLimitShard, rateLimitShards)}
+	for i := range s.shards {
+		s.shards[i].tats = make(map[string]time.Time)
+	}
+	return s
+}
+
+// Take implements RateLimitStore with the generic cell rate algorithm, it panics if the limit is not valid
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) RateLimitResult {
+	if !limit.Valid() {
+		panic(errInvalidRateLimit)
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Requests
+	}
+	interval := limit.Period / time.Duration(limit.Requests)
+	capacity := interval * time.Duration(burst)
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%uint32(len(s.shards))]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if now.Sub(shard.lastSweep) > rateLimitSweepInterval {
+		shard.sweep(now)
+	}
+
+	tat := shard.tats[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-capacity)
+
+	res := RateLimitResult{Limit: burst}
+	if now.Before(allowAt) {
+		res.RetryAfter = allowAt.Sub(now)
+		res.ResetAfter = tat.Sub(now)
+		return res
+	}
+
+	shard.tats[key] = newTat
+	res.Allowed = true
+	res.Remaining = int(now.Sub(allowAt) / interval)
+	res.ResetAfter = newTat.Sub(now)
+	return res
+}
+
+// remove keys that are back to a full burst
+func (rs *rateLimitShard) sweep(now time.Time) {
+	for k, tat := range rs.tats {
+		if !tat.After(now) {
+			delete(rs.tats, k)
+		}
+	}
+	rs.lastSweep = now
+}
+
+//*********************************************************************************************************************
+// RateLimiter
+
+// default responce when the limit is reached
+func defaultLimitReached(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+	router.RenderProblem(w, r, &router.Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(http.StatusTooManyRequests),
+		Status:   http.StatusTooManyRequests,
+		Instance: r.URL.Path,
+	})
+}
+
+// seconds rounded up as used by rate limit headers
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// NewRateLimiter creates a rate limiter, it panics if the limit is not valid
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	if !config.Limit.Valid() {
+		panic(errInvalidRateLimit)
+	}
+
+	rl := &RateLimiter{
+		limit:        config.Limit,
+		key:          config.Key,
+		store:        config.Store,
+		perRoute:     config.PerRoute,
+		limitReached: config.LimitReached,
+	}
+	if rl.key == nil {
+		rl.key = KeyByIP()
+	}
+	if rl.store == nil {
+		rl.store = NewMemoryRateLimitStore()
+	}
+	if rl.limitReached == nil {
+		rl.limitReached = defaultLimitReached
+	}
+	return rl
+}
+
+// check the limit and set the headers, returns false if the request must be rejected
+func (rl *RateLimiter) allow(w http.ResponseWriter, r *http.Request, p *router.ParameterList, route *router.Route) bool {
+	key := rl.key(r, p)
+	if key == "" {
+		return true
+	}
+	if rl.perRoute && route != nil {
+		key = route.Method + " " + route.Pattern() + "|" + key
+	}
+
+	res := rl.store.Take(key, rl.limit, time.Now())
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", ceilSeconds(res.ResetAfter))
+	if !res.Allowed {
+		h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+	}
+	return res.Allowed
+}
+
+// Handler limits the requests of a single handler
+func (rl *RateLimiter) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		if !rl.allow(w, r, p, p.Route()) {
+			rl.limitReached(w, r, p)
+			return
+		}
+		handler(w, r, p)
+	}
+}
+
+// Global returns a router that limits all the requests, parameter keys can't be used here
+// it panics if the limiter is PerRoute, use Handler on every route instead
+func (rl *RateLimiter) Global(inner http.Handler) http.Handler {
+	if rl.perRoute {
+		panic(errPerRouteGlobal)
+	}
+	return &rateLimitRouter{inner: inner, limiter: rl}
+}
+
+func (lr *rateLimitRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !lr.limiter.allow(w, r, nil, nil) {
+		lr.limiter.limitReached(w, r, nil)
+		return
+	}
+	lr.inner.ServeHTTP(w, r)
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 2, Period: time.Second}
+	now := time.Now()
+
+	if res := store.Take("a", limit, now); !res.Allowed || res.Remaining != 1 || res.Limit != 2 {
+		t.Fatalf("Unexpected first result: %+v", res)
+	}
+	if res := store.Take("a", limit, now); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Unexpected second result: %+v", res)
+	}
+	res := store.Take("a", limit, now)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("Third request should be limited: %+v", res)
+	}
+	if res := store.Take("b", limit, now); !res.Allowed {
+		t.Errorf("Keys should not share limits")
+	}
+	if res := store.Take("a", limit, now.Add(500*time.Millisecond)); !res.Allowed {
+		t.Errorf("Request should be allowed after the retry time")
+	}
+
+	// everything is back to a full burst and removed by the sweep
+	store.Take("c", limit, now.Add(2*time.Minute))
+	for i := range store.shards {
+		if len(store.shards[i].tats) > 1 {
+			t.Errorf("Expired keys not removed: %v", store.shards[i].tats)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	perKey := NewRateLimiter(RateLimitConfig{
+		Limit: RateLimit{Requests: 1, Period: time.Hour},
+		Key:   KeyByParam("key"),
+		LimitReached: func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	})
+	global := NewRateLimiter(RateLimitConfig{
+		Limit: RateLimit{Requests: 2, Period: time.Hour},
+		Key:   KeyByHeader("X-Api-Key"),
+	})
+
+	rt := router.MakeRouter()
+	rt.GET("/keys/:key", perKey.Handler(printHello))
+	handler := global.Global(rt)
+
+	res := runRequest(handler, "GET", "/keys/one", nil)
+	if res.Code != 200 || res.Header().Get("RateLimit-Limit") != "1" || res.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("Unexpected first responce: %d %v", res.Code, res.Header())
+	}
+	if res = runRequest(handler, "GET", "/keys/one", nil); res.Code != http.StatusServiceUnavailable {
+		t.Errorf("Custom limit handler not used: %d", res.Code)
+	}
+	if res = runRequest(handler, "GET", "/keys/two", nil); res.Code != 200 {
+		t.Errorf("Different parameter should not be limited: %d", res.Code)
+	}
+
+	apiKey := map[string]string{"X-Api-Key": "secret"}
+	runRequest(handler, "GET", "/keys/three", apiKey)
+	runRequest(handler, "GET", "/keys/four", apiKey)
+	res = runRequest(handler, "GET", "/keys/five", apiKey)
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "1800" ||
+		res.Header().Get("RateLimit-Reset") != "3600" {
+		t.Errorf("Unexpected global limit responce: %d %v", res.Code, res.Header())
+	}
+}
+
+func TestRateLimitPerRoute(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{Limit: RateLimit{Requests: 1, Period: time.Hour}, PerRoute: true})
+
+	rt := router.MakeRouter()
+	rt.GET("/users/:id", limiter.Handler(printHello))
+	rt.GET("/posts/:id", limiter.Handler(printHello))
+
+	if res := runRequest(rt, "GET", "/users/1", nil); res.Code != 200 {
+		t.Fatalf("First request limited: %d", res.Code)
+	}
+	if res := runRequest(rt, "GET", "/posts/1", nil); res.Code != 200 {
+		t.Errorf("Routes should not share the limit: %d", res.Code)
+	}
+	if res := runRequest(rt, "GET", "/users/2", nil); res.Code != http.StatusTooManyRequests {
+		t.Errorf("Paths of the same route should share the limit: %d", res.Code)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Per route limiter accepted as global middleware")
+		}
+	}()
+	limiter.Global(rt)
+}
+
+func TestInvalidRateLimit(t *testing.T) {
+	limits := []RateLimit{{Requests: 0, Period: time.Second}, {Requests: 10, Period: 0}, {Requests: 10, Period: 5}}
+	for _, limit := range limits {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Invalid limit accepted: %+v", limit)
+				}
+			}()
+			NewRateLimiter(RateLimitConfig{Limit: limit})
+		}()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Invalid limit accepted by the store: %+v", limit)
+				}
+			}()
+			NewMemoryRateLimitStore().Take("key", limit, time.Now())
+		}()
+	}
+
+	if !(RateLimit{Requests: 10, Period: 10}).Valid() {
+		t.Errorf("One request per nanosecond should be valid")
+	}
+}
@@ -0,0 +1,290 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// ProxyConfig describes the proxies in front of the server
+type ProxyConfig struct {
+	// TrustedProxies are the ips or CIDR ranges (es: 10.0.0.0/8, fd00::/8) allowed to set forwarding headers
+	TrustedProxies []string
+	// IgnoreForwarded skips the Forwarded header and uses only X-Forwarded-For/Proto/Host
+	IgnoreForwarded bool
+}
+
+// RemoteClient is the client of a request as seen by the first trusted proxy
+type RemoteClient struct {
+	IP     string
+	Scheme string // http or https
+	Host   string // host requested by the client
+	// Proxied is true when the values come from forwarding headers
+	Proxied bool
+}
+
+// ProxyResolver finds the real client of requests sent by trusted proxies and stores it in the request context
+type ProxyResolver struct {
+	trusted         []*net.IPNet
+	ignoreForwarded bool
+}
+
+type proxyRouter struct {
+	inner    http.Handler
+	resolver *ProxyResolver
+}
+
+// a proxy hop with the values it forwarded
+type forwardedHop struct {
+	ip    string
+	proto string
+	host  string
+}
+
+type remoteClientKey struct{}
+
+//*********************************************************************************************************************
+// RemoteClient
+
+// the client that sent the request directly
+func directClient(r *http.Request) RemoteClient {
+	client := RemoteClient{IP: r.RemoteAddr, Scheme: "http", Host: r.Host}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client.IP = host
+	}
+	if r.TLS != nil {
+		client.Scheme = "https"
+	}
+	return client
+}
+
+// GetRemoteClient returns the client resolved by a ProxyResolver, or the direct client if no resolver was used
+func GetRemoteClient(r *http.Request) RemoteClient {
+	if client, ok := r.Context().Value(remoteClientKey{}).(RemoteClient); ok {
+		return client
+	}
+	return directClient(r)
+}
+
+// ClientIP returns the ip of the client resolved by a ProxyResolver, or the host of RemoteAddr
+func ClientIP(r *http.Request) string {
+	return GetRemoteClient(r).IP
+}
+
+//*********************************************************************************************************************
+// ProxyResolver
+
+// parse an ip or a CIDR range
+func parseIPNet(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: value}
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+// NewProxyResolver creates a resolver, it panics if a trusted proxy is not a valid ip or CIDR range
+func NewProxyResolver(config ProxyConfig) *ProxyResolver {
+	pr := &ProxyResolver{ignoreForwarded: config.IgnoreForwarded}
+	for _, value := range config.TrustedProxies {
+		network, err := parseIPNet(value)
+		if err != nil {
+			panic("Invalid trusted proxy " + value + ": " + err.Error())
+		}
+		pr.trusted = append(pr.trusted, network)
+	}
+	return pr
+}
+
+// check if an ip is a trusted proxy
+func (pr *ProxyResolver) isTrusted(value string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+	for _, network := range pr.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove quotes, brackets and port from a Forwarded for value (es: "[2001:db8::1]:4711")
+func forwardedNode(value string) string {
+	value = strings.Trim(value, `"`)
+	if strings.HasPrefix(value, "[") {
+		if end := strings.Index(value, "]"); end > 0 {
+			return value[1:end]
+		}
+		return value
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return value
+}
+
+// parse the Forwarded headers (RFC 7239), hops are in the order they were added
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+	for _, header := range values {
+		for _, element := range strings.Split(header, ",") {
+			var hop forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				eq := strings.Index(pair, "=")
+				if eq < 0 {
+					continue
+				}
+				value := strings.Trim(strings.TrimSpace(pair[eq+1:]), `"`)
+				switch strings.ToLower(strings.TrimSpace(pair[:eq])) {
+				case "for":
+					hop.ip = forwardedNode(value)
+				case "proto":
+					hop.proto = strings.ToLower(value)
+				case "host":
+					hop.host = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// split a comma separated forwarding header, values of repeated headers are joined in order
+func splitForwardedValues(h http.Header, name string) []string {
+	var values []string
+	for _, header := range h[name] {
+		for _, value := range strings.Split(header, ",") {
+			values = append(values, strings.TrimSpace(value))
+		}
+	}
+	return values
+}
+
+// value of a X-Forwarded-Proto/Host list for a hop: proxies that append to all the headers keep the lists aligned
+// with X-Forwarded-For, otherwise the last value is used because it's the one set by the nearest (trusted) proxy
+// the first values are never used alone since the client can send them
+func forwardedValueAt(values []string, hop, hops int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	if len(values) == hops {
+		return values[hop]
+	}
+	return values[len(values)-1]
+}
+
+// parse the X-Forwarded-* headers
+func parseXForwarded(h http.Header) []forwardedHop {
+	ips := splitForwardedValues(h, "X-Forwarded-For")
+	protos := splitForwardedValues(h, "X-Forwarded-Proto")
+	hosts := splitForwardedValues(h, "X-Forwarded-Host")
+
+	hops := make([]forwardedHop, len(ips))
+	for i, ip := range ips {
+		hops[i] = forwardedHop{
+			ip:    forwardedNode(ip),
+			proto: strings.ToLower(forwardedValueAt(protos, i, len(ips))),
+			host:  forwardedValueAt(hosts, i, len(ips)),
+		}
+	}
+	return hops
+}
+
+// Resolve returns the client of a request, forwarding headers are used only when RemoteAddr is trusted
+// the client is the last hop that is not a trusted proxy, so spoofed values added by the client are ignored
+func (pr *ProxyResolver) Resolve(r *http.Request) RemoteClient {
+	client := directClient(r)
+	if !pr.isTrusted(client.IP) {
+		return client
+	}
+
+	var hops []forwardedHop
+	if !pr.ignoreForwarded {
+		hops = parseForwarded(r.Header["Forwarded"])
+	}
+	if len(hops) == 0 {
+		hops = parseXForwarded(r.Header)
+	}
+	if len(hops) == 0 {
+		return client
+	}
+
+	// walk back from the nearest proxy, stop at the first untrusted hop
+	i := len(hops) - 1
+	for i > 0 && pr.isTrusted(hops[i].ip) {
+		i--
+	}
+	hop := hops[i]
+	if net.ParseIP(hop.ip) == nil {
+		// obfuscated or unknown node, the client can't be identified
+		return client
+	}
+
+	client.IP = hop.ip
+	client.Proxied = true
+	if hop.proto == "http" || hop.proto == "https" {
+		client.Scheme = hop.proto
+	}
+	if hop.host != "" {
+		client.Host = hop.host
+	}
+	return client
+}
+
+// store the client in the request context
+func (pr *ProxyResolver) withClient(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), remoteClientKey{}, pr.Resolve(r)))
+}
+
+// Handler resolves the client of a single handler
+func (pr *ProxyResolver) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		handler(w, pr.withClient(r), p)
+	}
+}
+
+// Global returns a router that resolves the client of all the requests, it should be the outermost middleware
+// so logs, rate limits and ip filters see the real client
+func (pr *ProxyResolver) Global(inner http.Handler) http.Handler {
+	return &proxyRouter{inner: inner, resolver: pr}
+}
+
+func (pr *proxyRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pr.inner.ServeHTTP(w, pr.resolver.withClient(r))
+}
+
+//*********************************************************************************************************************
+
+// GlobalRealIP returns a router that trusts the forwarding headers sent by the given proxies
+func GlobalRealIP(router http.Handler, trustedProxies ...string) http.Handler {
+	return NewProxyResolver(ProxyConfig{TrustedProxies: trustedProxies}).Global(router)
+}
@@ -0,0 +1,116 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestProxyResolver(t *testing.T) {
+	resolver := NewProxyResolver(ProxyConfig{TrustedProxies: []string{"10.0.0.0/8", "fd00::/8", "192.168.1.1"}})
+
+	tests := []struct {
+		remote  string
+		headers map[string]string
+		client  RemoteClient
+	}{
+		{"203.0.113.5:1234", map[string]string{"X-Forwarded-For": "1.1.1.1"},
+			RemoteClient{IP: "203.0.113.5", Scheme: "http", Host: "example.com"}},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.1.1.1, 10.0.0.2", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.example.com"},
+			RemoteClient{IP: "1.1.1.1", Scheme: "https", Host: "api.example.com", Proxied: true}},
+		{"192.168.1.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https;host=shop.example.com, for=10.1.1.1`, "X-Forwarded-For": "9.9.9.9"},
+			RemoteClient{IP: "2001:db8::1", Scheme: "https", Host: "shop.example.com", Proxied: true}},
+		{"[fd00::1]:1234", map[string]string{"Forwarded": "for=_hidden"},
+			RemoteClient{IP: "fd00::1", Scheme: "http", Host: "example.com"}},
+		{"10.0.0.1:1234", nil,
+			RemoteClient{IP: "10.0.0.1", Scheme: "http", Host: "example.com"}},
+		// values sent by the client before the ones appended by the proxy
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.1.1.1", "X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "evil.com, shop.example.com"},
+			RemoteClient{IP: "1.1.1.1", Scheme: "http", Host: "shop.example.com", Proxied: true}},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.1.1.1", "X-Forwarded-Proto": "https, https, http", "X-Forwarded-Host": "evil.com, evil.com, shop.example.com"},
+			RemoteClient{IP: "1.1.1.1", Scheme: "http", Host: "shop.example.com", Proxied: true}},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		if client := resolver.Resolve(req); client != test.client {
+			t.Errorf("Wrong client for %s %v: %+v", test.remote, test.headers, client)
+		}
+	}
+}
+
+func TestProxyResolverMiddlewares(t *testing.T) {
+	var seen string
+	rt := router.MakeRouter()
+	rt.GET("/ip", func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		seen = ClientIP(r)
+	})
+	limiter := NewRateLimiter(RateLimitConfig{Limit: RateLimit{Requests: 1, Period: time.Hour}})
+	handler := GlobalRealIP(limiter.Global(rt), "10.0.0.0/8")
+
+	send := func(forwarded string) int {
+		req := httptest.NewRequest("GET", "/ip", nil)
+		req.RemoteAddr = "10.0.0.1:80"
+		req.Header.Set("X-Forwarded-For", forwarded)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if send("1.1.1.1") != http.StatusOK || seen != "1.1.1.1" {
+		t.Errorf("Client ip not resolved: %s", seen)
+	}
+	if send("2.2.2.2") != http.StatusOK {
+		t.Errorf("Clients behind the same proxy share the limit")
+	}
+	if send("1.1.1.1") != http.StatusTooManyRequests {
+		t.Errorf("Limit not applied to the resolved client")
+	}
+}
+
+func TestProxyResolverSpoofedOrigin(t *testing.T) {
+	var reason error
+	csrf := NewCSRF(CSRFConfig{Failed: func(w http.ResponseWriter, _ *http.Request, err error) {
+		reason = err
+		w.WriteHeader(http.StatusForbidden)
+	}})
+	rt := router.MakeRouter()
+	rt.POST("/form", printHello)
+	handler := GlobalRealIP(csrf.Global(rt), "10.0.0.0/8")
+
+	// the client claims the request was sent to https://evil.com, the proxy appends what it really received
+	req := httptest.NewRequest("POST", "/form", nil)
+	req.RemoteAddr = "10.0.0.1:80"
+	req.Header.Set("Origin", "https://evil.com")
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	req.Header.Set("X-Forwarded-Proto", "https, http")
+	req.Header.Set("X-Forwarded-Host", "evil.com, example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || reason != ErrCSRFOrigin {
+		t.Errorf("Spoofed forwarding headers accepted as origin: %d %v", rec.Code, reason)
+	}
+}
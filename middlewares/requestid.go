@@ -0,0 +1,161 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// RequestIDHeader is the default header used to read and send request ids
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDConfig describes how request ids are read and generated
+type RequestIDConfig struct {
+	// Header used for incoming and outgoing ids, default is X-Request-ID
+	Header string
+	// Generate creates a new id, default is 16 random bytes in hex
+	Generate func() string
+	// Validate checks incoming ids, invalid ids are replaced by a new one
+	// default accepts up to 128 letters, digits and - _ . : + / =
+	Validate func(id string) bool
+	// IgnoreIncoming always generates a new id
+	IgnoreIncoming bool
+}
+
+// RequestIdentifier reuses the id sent by the client or generates a new one and echoes it in the responce
+// handlers read the id with router.RequestID
+type RequestIdentifier struct {
+	header         string
+	generate       func() string
+	validate       func(string) bool
+	ignoreIncoming bool
+}
+
+type requestIDRouter struct {
+	inner http.Handler
+	ids   *RequestIdentifier
+}
+
+var defaultRequestIdentifier = NewRequestIdentifier(RequestIDConfig{})
+
+// used when the random source fails
+var fallbackRequestID uint64
+
+//*********************************************************************************************************************
+
+// generate a random hex id
+func randomRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		n := atomic.AddUint64(&fallbackRequestID, 1)
+		return strconv.FormatInt(time.Now().UnixNano(), 16) + "-" + strconv.FormatUint(n, 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// check that an incoming id is safe to log and echo
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':' || c == '+' || c == '/' || c == '=') {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestIdentifier creates a request id middleware
+func NewRequestIdentifier(config RequestIDConfig) *RequestIdentifier {
+	ri := &RequestIdentifier{
+		header:         http.CanonicalHeaderKey(config.Header),
+		generate:       config.Generate,
+		validate:       config.Validate,
+		ignoreIncoming: config.IgnoreIncoming,
+	}
+	if ri.header == "" {
+		ri.header = RequestIDHeader
+	}
+	if ri.generate == nil {
+		ri.generate = randomRequestID
+	}
+	if ri.validate == nil {
+		ri.validate = validRequestID
+	}
+	return ri
+}
+
+// take the incoming id or make a new one, then send it back to the client
+func (ri *RequestIdentifier) identify(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := router.RequestID(r)
+	if id == "" && !ri.ignoreIncoming {
+		id = r.Header.Get(ri.header)
+	}
+	if !ri.validate(id) {
+		id = ri.generate()
+	}
+
+	w.Header().Set(ri.header, id)
+	return router.WithRequestID(r, id)
+}
+
+// Handler adds an id to the requests of a single handler
+// panic reports of the router include the id only when Global is used
+func (ri *RequestIdentifier) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		handler(w, ri.identify(w, r), p)
+	}
+}
+
+// Global returns a router that adds an id to every request
+func (ri *RequestIdentifier) Global(inner http.Handler) http.Handler {
+	return &requestIDRouter{inner: inner, ids: ri}
+}
+
+func (rr *requestIDRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.inner.ServeHTTP(w, rr.ids.identify(w, r))
+}
+
+// id of a request, loggers placed outside of the request id middleware can only find it in the responce
+func requestIDOf(w http.ResponseWriter, r *http.Request) string {
+	if id := router.RequestID(r); id != "" {
+		return id
+	}
+	return w.Header().Get(RequestIDHeader)
+}
+
+//*********************************************************************************************************************
+
+// RequestID adds an id to the requests of a handler using X-Request-ID
+func RequestID(handler router.RequestHandler) router.RequestHandler {
+	return defaultRequestIdentifier.Handler(handler)
+}
+
+// GlobalRequestID returns a router that adds an id to every request using X-Request-ID
+func GlobalRequestID(router http.Handler) http.Handler {
+	return defaultRequestIdentifier.Global(router)
+}
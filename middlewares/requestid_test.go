@@ -0,0 +1,78 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	var report *router.PanicReport
+
+	rt := router.MakeRouter()
+	rt.GET("/id", func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		seen = router.RequestID(r)
+	})
+	rt.GET("/panic", func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		panic("boom")
+	})
+	rt.SetLogger(router.NopLogger{})
+	rt.SetPanicHandler(func(w http.ResponseWriter, _ *http.Request, pr *router.PanicReport) {
+		report = pr
+		w.WriteHeader(500)
+	})
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	handler := GlobalSimpleRequestLogging(GlobalRequestID(rt))
+
+	res := runRequest(handler, "GET", "/id", map[string]string{"X-Request-ID": "abc-123"})
+	if seen != "abc-123" || res.Header().Get("X-Request-ID") != "abc-123" {
+		t.Errorf("Incoming id not used: %q %q", seen, res.Header().Get("X-Request-ID"))
+	}
+	if !strings.Contains(out.String(), "[abc-123]") {
+		t.Errorf("Request id missing from log: %s", out.String())
+	}
+
+	res = runRequest(handler, "GET", "/id", map[string]string{"X-Request-ID": "bad id\"<script>"})
+	if seen == "" || strings.Contains(seen, " ") || res.Header().Get("X-Request-ID") != seen {
+		t.Errorf("Invalid id not replaced: %q", seen)
+	}
+
+	runRequest(handler, "GET", "/panic", nil)
+	if report == nil || report.RequestID == "" {
+		t.Errorf("Request id missing from panic report")
+	}
+
+	custom := NewRequestIdentifier(RequestIDConfig{Header: "X-Trace", Generate: func() string { return "fixed" }, IgnoreIncoming: true})
+	rt.GET("/single", custom.Handler(func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		seen = router.RequestID(r)
+	}))
+	res = runRequest(rt, "GET", "/single", map[string]string{"X-Trace": "incoming"})
+	if seen != "fixed" || res.Header().Get("X-Trace") != "fixed" {
+		t.Errorf("Custom identifier not used: %q", seen)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// SecurityConfig describes the security headers sent with every responce, empty fields are not sent
+type SecurityConfig struct {
+	// HSTSMaxAge enables Strict-Transport-Security
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// NoSniff sends X-Content-Type-Options: nosniff
+	NoSniff bool
+	// FrameOptions is the X-Frame-Options value (DENY or SAMEORIGIN), use frame-ancestors in the CSP for finer control
+	FrameOptions              string
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	// CSP is the Content-Security-Policy, nil to not send it
+	CSP *ContentSecurityPolicy
+	// CSPReportOnly sends the CSP as Content-Security-Policy-Report-Only
+	CSPReportOnly bool
+}
+
+// SecurityHeaders sends the configured security headers and a fresh CSP nonce with every responce
+type SecurityHeaders struct {
+	static        [][2]string // headers that don't change between requests
+	csp           *ContentSecurityPolicy
+	cspHeader     string
+	cspNeedsNonce bool
+}
+
+// ContentSecurityPolicy is a Content-Security-Policy builder
+type ContentSecurityPolicy struct {
+	directives []cspDirective
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+	nonce   bool
+}
+
+type securityRouter struct {
+	inner    http.Handler
+	security *SecurityHeaders
+}
+
+type cspNonceKey struct{}
+
+var defaultSecurityHeaders = NewSecurityHeaders(DefaultSecurityConfig())
+
+//*********************************************************************************************************************
+// ContentSecurityPolicy
+
+// NewCSP creates an empty policy es: NewCSP().Add("default-src", "'self'").WithNonce("script-src", "'strict-dynamic'")
+func NewCSP() *ContentSecurityPolicy {
+	return &ContentSecurityPolicy{}
+}
+
+// add sources to a directive, creating it if needed
+func (csp *ContentSecurityPolicy) directive(name string, nonce bool, sources []string) *ContentSecurityPolicy {
+	for i := range csp.directives {
+		if csp.directives[i].name == name {
+			csp.directives[i].sources = append(csp.directives[i].sources, sources...)
+			csp.directives[i].nonce = csp.directives[i].nonce || nonce
+			return csp
+		}
+	}
+	csp.directives = append(csp.directives, cspDirective{name: name, sources: sources, nonce: nonce})
+	return csp
+}
+
+// Add adds sources to a directive (es: "img-src", "'self'", "data:"), a directive without sources is a flag
+// (es: "upgrade-insecure-requests")
+func (csp *ContentSecurityPolicy) Add(directive string, sources ...string) *ContentSecurityPolicy {
+	return csp.directive(directive, false, sources)
+}
+
+// WithNonce adds sources to a directive and the nonce of the request, use it for script-src and style-src
+func (csp *ContentSecurityPolicy) WithNonce(directive string, sources ...string) *ContentSecurityPolicy {
+	return csp.directive(directive, true, sources)
+}
+
+// FrameAncestors sets the frame-ancestors directive, the CSP replacement of X-Frame-Options
+func (csp *ContentSecurityPolicy) FrameAncestors(sources ...string) *ContentSecurityPolicy {
+	return csp.directive("frame-ancestors", false, sources)
+}
+
+// Build returns the header value for a nonce
+func (csp *ContentSecurityPolicy) Build(nonce string) string {
+	parts := make([]string, 0, len(csp.directives))
+	for _, d := range csp.directives {
+		value := d.name
+		if len(d.sources) > 0 {
+			value += " " + strings.Join(d.sources, " ")
+		}
+		if d.nonce && nonce != "" {
+			value += " 'nonce-" + nonce + "'"
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// check if the policy uses a nonce
+func (csp *ContentSecurityPolicy) usesNonce() bool {
+	for _, d := range csp.directives {
+		if d.nonce {
+			return true
+		}
+	}
+	return false
+}
+
+// CSPNonce returns the nonce of a request, handlers and templates use it as nonce attribute of inline scripts and styles
+// the nonce is empty if the request did not pass through a security middleware with a nonce based CSP
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// generate a random nonce
+func newCSPNonce() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// a predictable nonce would make the policy useless
+		panic("Unable to generate a CSP nonce: " + err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+//*********************************************************************************************************************
+// SecurityHeaders
+
+// DefaultSecurityConfig returns a strict configuration for APIs: one year HSTS with subdomains, nosniff,
+// no framing, no referrer to other origins and isolated browsing context
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		HSTSMaxAge:              365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:   true,
+		NoSniff:                 true,
+		FrameOptions:            "DENY",
+		ReferrerPolicy:          "strict-origin-when-cross-origin",
+		CrossOriginOpenerPolicy: "same-origin",
+	}
+}
+
+// NewSecurityHeaders creates a security headers middleware
+func NewSecurityHeaders(config SecurityConfig) *SecurityHeaders {
+	sh := &SecurityHeaders{csp: config.CSP}
+	add := func(name, value string) {
+		if value != "" {
+			sh.static = append(sh.static, [2]string{name, value})
+		}
+	}
+
+	if config.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(config.HSTSMaxAge/time.Second), 10)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if config.HSTSPreload {
+			hsts += "; preload"
+		}
+		add("Strict-Transport-Security", hsts)
+	}
+	if config.NoSniff {
+		add("X-Content-Type-Options", "nosniff")
+	}
+	add("X-Frame-Options", config.FrameOptions)
+	add("Referrer-Policy", config.ReferrerPolicy)
+	add("Permissions-Policy", config.PermissionsPolicy)
+	add("Cross-Origin-Opener-Policy", config.CrossOriginOpenerPolicy)
+	add("Cross-Origin-Embedder-Policy", config.CrossOriginEmbedderPolicy)
+
+	if sh.csp != nil {
+		sh.cspHeader = "Content-Security-Policy"
+		if config.CSPReportOnly {
+			sh.cspHeader = "Content-Security-Policy-Report-Only"
+		}
+		sh.cspNeedsNonce = sh.csp.usesNonce()
+		if !sh.cspNeedsNonce {
+			add(sh.cspHeader, sh.csp.Build(""))
+		}
+	}
+
+	return sh
+}
+
+// set the headers, returns the request with the nonce if the CSP needs one
+func (sh *SecurityHeaders) apply(w http.ResponseWriter, r *http.Request) *http.Request {
+	h := w.Header()
+	for _, header := range sh.static {
+		h.Set(header[0], header[1])
+	}
+
+	if sh.cspNeedsNonce {
+		nonce := newCSPNonce()
+		h.Set(sh.cspHeader, sh.csp.Build(nonce))
+		r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+	}
+	return r
+}
+
+// Handler sets the security headers of a single handler
+func (sh *SecurityHeaders) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		handler(w, sh.apply(w, r), p)
+	}
+}
+
+// Global returns a router that sets the security headers of all the responces
+func (sh *SecurityHeaders) Global(inner http.Handler) http.Handler {
+	return &securityRouter{inner: inner, security: sh}
+}
+
+func (sr *securityRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sr.inner.ServeHTTP(w, sr.security.apply(w, r))
+}
+
+//*********************************************************************************************************************
+
+// Secure sets the headers of DefaultSecurityConfig for a handler
+func Secure(handler router.RequestHandler) router.RequestHandler {
+	return defaultSecurityHeaders.Handler(handler)
+}
+
+// GlobalSecure returns a router that sets the headers of DefaultSecurityConfig for all the paths
+func GlobalSecure(router http.Handler) http.Handler {
+	return defaultSecurityHeaders.Global(router)
+}
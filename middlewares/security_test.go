@@ -0,0 +1,97 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	config := DefaultSecurityConfig()
+	config.HSTSPreload = true
+	config.HSTSMaxAge = 2 * 365 * 24 * time.Hour
+	config.PermissionsPolicy = "geolocation=()"
+	config.CrossOriginEmbedderPolicy = "require-corp"
+	config.CSP = NewCSP().Add("default-src", "'self'").WithNonce("script-src", "'strict-dynamic'").
+		FrameAncestors("'none'").Add("upgrade-insecure-requests")
+	security := NewSecurityHeaders(config)
+
+	var nonce string
+	rt := router.MakeRouter()
+	rt.GET("/page", func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		nonce = CSPNonce(r)
+		w.Write([]byte(`<script nonce="` + nonce + `"></script>`))
+	})
+	handler := security.Global(rt)
+
+	res := runRequest(handler, "GET", "/page", nil)
+	expected := map[string]string{
+		"Strict-Transport-Security":    "max-age=63072000; includeSubDomains; preload",
+		"X-Content-Type-Options":       "nosniff",
+		"X-Frame-Options":              "DENY",
+		"Referrer-Policy":              "strict-origin-when-cross-origin",
+		"Permissions-Policy":           "geolocation=()",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "require-corp",
+		"Content-Security-Policy": "default-src 'self'; script-src 'strict-dynamic' 'nonce-" + nonce +
+			"'; frame-ancestors 'none'; upgrade-insecure-requests",
+	}
+	for name, value := range expected {
+		if got := res.Header().Get(name); got != value {
+			t.Errorf("Wrong %s: %q, expected %q", name, got, value)
+		}
+	}
+	if nonce == "" || !strings.Contains(res.Body.String(), nonce) {
+		t.Errorf("Nonce not available to the handler")
+	}
+
+	first := nonce
+	runRequest(handler, "GET", "/page", nil)
+	if nonce == first {
+		t.Errorf("Nonce reused between requests")
+	}
+}
+
+func TestSecurityHeadersRoute(t *testing.T) {
+	reportOnly := NewSecurityHeaders(SecurityConfig{CSP: NewCSP().Add("default-src", "'none'"), CSPReportOnly: true})
+
+	rt := router.MakeRouter()
+	rt.GET("/api", Secure(func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+		if CSPNonce(r) != "" {
+			t.Errorf("Nonce generated without a nonce directive")
+		}
+	}))
+	rt.GET("/report", reportOnly.Handler(printHello))
+	rt.GET("/plain", printHello)
+
+	if res := runRequest(rt, "GET", "/api", nil); res.Header().Get("X-Frame-Options") != "DENY" ||
+		res.Header().Get("Content-Security-Policy") != "" {
+		t.Errorf("Unexpected default headers: %v", res.Header())
+	}
+	if res := runRequest(rt, "GET", "/report", nil); res.Header().Get("Content-Security-Policy-Report-Only") != "default-src 'none'" ||
+		res.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("Unexpected report only headers: %v", res.Header())
+	}
+	if res := runRequest(rt, "GET", "/plain", nil); res.Header().Get("X-Content-Type-Options") != "" {
+		t.Errorf("Headers set on a route without the middleware")
+	}
+}
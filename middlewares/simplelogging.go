@@ -24,16 +24,25 @@ import (
 	"github.com/rickycorte/pantofola-rest/router"
 )
 
+// write the log line of a request, the request id is added when there is one
+func logRequest(method, pattern string, r *http.Request, writer *router.ResponseWriter, delta int64) {
+	if id := requestIDOf(writer, r); id != "" {
+		log.Printf("HTTP %s %s (%s) - %d in %.2fms [%s]\n", method, pattern, r.URL, writer.Status(), float64(delta)/1000000, id)
+		return
+	}
+	log.Printf("HTTP %s %s (%s) - %d in %.2fms\n", method, pattern, r.URL, writer.Status(), float64(delta)/1000000)
+}
+
 // SimpleRequestLogging is a single handler middleware that logs base information about the executed handler
-// like the url, request status, process time
+// like the route pattern, the url, request status, process time
 func SimpleRequestLogging(handler router.RequestHandler) router.RequestHandler {
 
 	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
 		start := time.Now().UnixNano()
-		writer := &captureResponceWriter{w, http.StatusOK}
+		writer := router.WrapResponseWriter(w)
 		handler(writer, r, p)
 		delta := time.Now().UnixNano() - start
-		log.Printf("HTTP %s %s - %d in %.2fms\n", r.Method, r.URL, writer.status, float64(delta)/1000000)
+		logRequest(r.Method, p.Route().Pattern(), r, writer, delta)
 	}
 }
 
@@ -43,14 +52,15 @@ type logginRouter struct {
 
 func (lr *logginRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now().UnixNano()
-	writer := &captureResponceWriter{w, 0}
+	writer := router.WrapResponseWriter(w)
+	r, tracker := router.TrackRoute(r)
 	lr.inner.ServeHTTP(writer, r)
 	delta := time.Now().UnixNano() - start
-	log.Printf("HTTP %s %s - %d in %.2fms\n", r.Method, r.URL, writer.status, float64(delta)/1000000)
+	logRequest(r.Method, tracker.Pattern(), r, writer, delta)
 }
 
 // GlobalSimpleRequestLogging is a router middleware that logs base information about all the requests passed to the router
-// like the url, request status, process time
+// like the matched route pattern, the url, request status, process time
 func GlobalSimpleRequestLogging(router http.Handler) http.Handler {
 
 	return &logginRouter{inner: router}
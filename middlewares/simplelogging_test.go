@@ -0,0 +1,70 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// recorder that can also be hijacked
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (hr *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hr.hijacked = true
+	return nil, nil, nil
+}
+
+func TestLoggingKeepsWriterInterfaces(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/stream", SimpleRequestLogging(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.Write([]byte("data"))
+		w.(http.Flusher).Flush()
+		if _, _, err := w.(http.Hijacker).Hijack(); err != nil {
+			t.Errorf("Hijack failed: %v", err)
+		}
+		if _, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok {
+			t.Errorf("Writer can't be unwrapped")
+		}
+	}))
+
+	recorder := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	GlobalSimpleRequestLogging(rt).ServeHTTP(recorder, httptest.NewRequest("GET", "/stream", nil))
+	if !recorder.Flushed || !recorder.hijacked || recorder.Body.String() != "data" {
+		t.Errorf("Writer interfaces were not forwarded")
+	}
+}
+
+func TestLoggingNotFoundHandler(t *testing.T) {
+	rt := router.MakeRouter()
+	rt.GET("/a", printHello)
+	rt.SetNotFoundHandler(SimpleRequestLogging(func(w http.ResponseWriter, _ *http.Request, _ *router.ParameterList) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	if res := runRequest(rt, "GET", "/missing", nil); res.Code != http.StatusNotFound {
+		t.Errorf("Unexpected status: %d", res.Code)
+	}
+}
@@ -0,0 +1,236 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+// TimeoutConfig describes how long a handler can run
+type TimeoutConfig struct {
+	Timeout time.Duration
+	// Status sent when the deadline passes, default is 503 (use 504 for gateways)
+	Status int
+	// TimeoutHandler writes the responce when the deadline passes, default is a problem with Status
+	TimeoutHandler router.RequestHandler
+	// Logger reports the panics of handlers that were already timed out, default is router.DefaultLogger()
+	Logger router.Logger
+}
+
+// TimeoutPolicy runs handlers with a context deadline and answers for them once it expires
+// handlers run in their own goroutine and their responce is buffered until they return,
+// writes done after the deadline are discarded and return http.ErrHandlerTimeout
+// panics are forwarded to the router with the stack of the handler, the ones after the deadline are logged
+type TimeoutPolicy struct {
+	timeout        time.Duration
+	status         int
+	timeoutHandler router.RequestHandler
+	logger         router.Logger
+}
+
+// buffered writer used by the handler goroutine
+type timeoutWriter struct {
+	header      http.Header
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+	mutex       sync.Mutex
+}
+
+type timeoutRouter struct {
+	inner  http.Handler
+	policy *TimeoutPolicy
+}
+
+//*********************************************************************************************************************
+// TimeoutPolicy
+
+// NewTimeout creates a timeout middleware, it panics if the timeout is not positive
+func NewTimeout(config TimeoutConfig) *TimeoutPolicy {
+	if config.Timeout <= 0 {
+		panic("Timeout must be positive")
+	}
+
+	tp := &TimeoutPolicy{timeout: config.Timeout, status: config.Status, timeoutHandler: config.TimeoutHandler, logger: config.Logger}
+	if tp.logger == nil {
+		tp.logger = router.DefaultLogger()
+	}
+	if tp.status == 0 {
+		tp.status = http.StatusServiceUnavailable
+	}
+	if tp.timeoutHandler == nil {
+		status := tp.status
+		tp.timeoutHandler = func(w http.ResponseWriter, r *http.Request, _ *router.ParameterList) {
+			router.RenderProblem(w, r, &router.Problem{
+				Type:     "about:blank",
+				Title:    http.StatusText(status),
+				Status:   status,
+				Detail:   "The request took too long to complete",
+				Instance: r.URL.Path,
+			})
+		}
+	}
+	return tp
+}
+
+// log a panic of a handler that was already timed out, the router answered for it and can't report it
+func (tp *TimeoutPolicy) logLatePanic(r *http.Request, fp *router.ForwardedPanic) {
+	if fp.Value == http.ErrAbortHandler {
+		return
+	}
+	tp.logger.Log(router.LevelError, "Panic after timeout", "panic", fp.Value, "method", r.Method,
+		"path", r.URL.Path, "request_id", router.RequestID(r), "stack", string(fp.Stack))
+}
+
+// run serve in a new goroutine and wait for it or for the deadline
+func (tp *TimeoutPolicy) run(w http.ResponseWriter, r *http.Request, p *router.ParameterList, serve func(http.ResponseWriter, *http.Request)) {
+	ctx, cancel := context.WithTimeout(r.Context(), tp.timeout)
+	defer cancel()
+	tr := r.WithContext(ctx)
+
+	tw := &timeoutWriter{header: make(http.Header)}
+	done := make(chan struct{})
+	panicked := make(chan *router.ForwardedPanic, 1)
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				fp := &router.ForwardedPanic{Value: err, Stack: debug.Stack()}
+				// decided under the lock so the panic is either forwarded or logged, never both or none
+				tw.mutex.Lock()
+				late := tw.timedOut
+				if !late {
+					panicked <- fp
+				}
+				tw.mutex.Unlock()
+				if late {
+					tp.logLatePanic(r, fp)
+				}
+				return
+			}
+			close(done)
+		}()
+		serve(tw, tr)
+	}()
+
+	select {
+	case fp := <-panicked:
+		// let the router panic handler deal with it
+		panic(fp)
+
+	case <-done:
+		tw.mutex.Lock()
+		defer tw.mutex.Unlock()
+
+		dst := w.Header()
+		for k, v := range tw.header {
+			dst[k] = v
+		}
+		if !tw.wroteHeader {
+			tw.status = http.StatusOK
+		}
+		w.WriteHeader(tw.status)
+		w.Write(tw.buf.Bytes())
+
+	case <-ctx.Done():
+		tw.mutex.Lock()
+		tw.timedOut = true
+		tw.mutex.Unlock()
+
+		// a panic sent while the deadline passed is not forwarded anymore
+		select {
+		case fp := <-panicked:
+			tp.logLatePanic(r, fp)
+		default:
+		}
+
+		// nobody is waiting for a responce if the client went away
+		if ctx.Err() == context.DeadlineExceeded {
+			tp.timeoutHandler(w, r, p)
+		}
+	}
+}
+
+// Handler limits the execution time of a single handler
+// the handler receives a copy of the parameters because the router reuses the original list when the deadline passes
+func (tp *TimeoutPolicy) Handler(handler router.RequestHandler) router.RequestHandler {
+	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		params := p.Clone()
+		tp.run(w, r, p, func(tw http.ResponseWriter, tr *http.Request) {
+			handler(tw, tr, params)
+		})
+	}
+}
+
+// Global returns a router that limits the execution time of all the requests, use it on a sub router for groups
+func (tp *TimeoutPolicy) Global(inner http.Handler) http.Handler {
+	return &timeoutRouter{inner: inner, policy: tp}
+}
+
+func (tr *timeoutRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr.policy.run(w, r, nil, tr.inner.ServeHTTP)
+}
+
+//*********************************************************************************************************************
+// timeoutWriter
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.status = code
+	tw.wroteHeader = true
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.status = http.StatusOK
+		tw.wroteHeader = true
+	}
+	return tw.buf.Write(b)
+}
+
+//*********************************************************************************************************************
+
+// Timeout limits the execution time of a handler, late responces get a 503
+func Timeout(timeout time.Duration, handler router.RequestHandler) router.RequestHandler {
+	return NewTimeout(TimeoutConfig{Timeout: timeout}).Handler(handler)
+}
+
+// GlobalTimeout returns a router that limits the execution time of all the requests, late responces get a 503
+func GlobalTimeout(timeout time.Duration, router http.Handler) http.Handler {
+	return NewTimeout(TimeoutConfig{Timeout: timeout}).Global(router)
+}
@@ -0,0 +1,136 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickycorte/pantofola-rest/router"
+)
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+	lateParam := make(chan string, 1)
+
+	rt := router.MakeRouter()
+	rt.SetLogger(router.NopLogger{})
+	rt.GET("/slow/:id", Timeout(20*time.Millisecond, func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		<-r.Context().Done()
+		time.Sleep(20 * time.Millisecond) // the router has already reused its parameter list
+		lateParam <- p.Get("id")
+		_, err := w.Write([]byte("late"))
+		lateWrite <- err
+	}))
+	rt.GET("/fast/:id", Timeout(time.Second, func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		w.Header().Set("X-Id", p.Get("id"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+	rt.GET("/panic", Timeout(time.Second, func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		panic("boom")
+	}))
+
+	res := runRequest(rt, "GET", "/slow/first", nil)
+	if res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", res.Code)
+	}
+	// reuse the pooled list while the slow handler is still running
+	runRequest(rt, "GET", "/fast/second", nil)
+	if id := <-lateParam; id != "first" {
+		t.Errorf("Timed out handler saw parameter %q", id)
+	}
+	if err := <-lateWrite; err != http.ErrHandlerTimeout {
+		t.Errorf("Late write should fail, got %v", err)
+	}
+
+	res = runRequest(rt, "GET", "/fast/ok", nil)
+	if res.Code != http.StatusCreated || res.Header().Get("X-Id") != "ok" || res.Body.String() != "done" {
+		t.Errorf("Unexpected fast responce: %d %v %q", res.Code, res.Header(), res.Body.String())
+	}
+
+	if res = runRequest(rt, "GET", "/panic", nil); res.Code != http.StatusInternalServerError {
+		t.Errorf("Panic not forwarded to the router: %d", res.Code)
+	}
+
+	gateway := NewTimeout(TimeoutConfig{Timeout: 10 * time.Millisecond, Status: http.StatusGatewayTimeout})
+	res = runRequest(gateway.Global(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})), "GET", "/", nil)
+	if res.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504, got %d", res.Code)
+	}
+}
+
+// sends every message to the channel as text
+type chanLogger chan string
+
+func (cl chanLogger) Log(_ router.LogLevel, msg string, fields ...interface{}) {
+	cl <- fmt.Sprint(msg, fields)
+}
+
+func panickingHandler(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+	panic("boom")
+}
+
+func TestTimeoutPanicStack(t *testing.T) {
+	var report *router.PanicReport
+	rt := router.MakeRouter()
+	rt.SetLogger(router.NopLogger{})
+	rt.SetPanicHandler(func(w http.ResponseWriter, _ *http.Request, pr *router.PanicReport) {
+		report = pr
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	rt.GET("/panic", Timeout(time.Second, panickingHandler))
+
+	runRequest(rt, "GET", "/panic", nil)
+	if report == nil || report.Value != "boom" {
+		t.Fatalf("Panic value not forwarded: %+v", report)
+	}
+	if !strings.Contains(string(report.Stack), "panickingHandler") {
+		t.Errorf("Report does not have the stack of the handler:\n%s", report.Stack)
+	}
+}
+
+func TestTimeoutLatePanic(t *testing.T) {
+	logger := make(chanLogger, 1)
+	policy := NewTimeout(TimeoutConfig{Timeout: 10 * time.Millisecond, Logger: logger})
+	handler := policy.Handler(func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		panickingHandler(w, r, p)
+	})
+
+	rt := router.MakeRouter()
+	rt.GET("/late", handler)
+	if res := runRequest(rt, "GET", "/late", nil); res.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", res.Code)
+	}
+
+	select {
+	case msg := <-logger:
+		if !strings.HasPrefix(msg, "Panic after timeout") || !strings.Contains(msg, "boom") ||
+			!strings.Contains(msg, "panickingHandler") {
+			t.Errorf("Unexpected log message: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Late panic not logged")
+	}
+}
@@ -1,30 +0,0 @@
-/*
-   Copyright 2020 rickycorte
-
-   Licensed under the Apache License, Version 2.0 (the "License");
-   you may not use this file except in compliance with the License.
-   You may obtain a copy of the License at
-
-   http://www.apache.org/licenses/LICENSE-2.0
-
-   Unless required by applicable law or agreed to in writing, software
-   distributed under the License is distributed on an "AS IS" BASIS,
-   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-   See the License for the specific language governing permissions and
-   limitations under the License.
-*/
-
-package middlewares
-
-import "net/http"
-
-// enable status capture for responce writer by creating a wrapper
-type captureResponceWriter struct {
-	http.ResponseWriter
-	status int
-}
-
-func (rec *captureResponceWriter) WriteHeader(code int) {
-	rec.status = code
-	rec.ResponseWriter.WriteHeader(code)
-}
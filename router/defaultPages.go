@@ -21,20 +21,36 @@ import (
 	"net/http"
 )
 
+// default pages are written with the router error handler so all the errors share the same format
+
+var (
+	errNotFound         = &HTTPError{Status: http.StatusNotFound}
+	errMethodNotAllowed = &HTTPError{Status: http.StatusMethodNotAllowed}
+)
+
 // default error page
-func defaultFallback(w http.ResponseWriter, _ *http.Request, _ *ParameterList) {
-	w.WriteHeader(404)
-	fmt.Fprintf(w, "Not Found")
+func (r *Router) defaultFallback(w http.ResponseWriter, req *http.Request, _ *ParameterList) {
+	r.errorHandler(w, req, errNotFound)
 }
 
 // default not allowed method responce
-func defaultNotAllowedMethod(w http.ResponseWriter, _ *http.Request, _ *ParameterList) {
-	w.WriteHeader(405)
-	fmt.Fprintf(w, "Method Not Allowed")
+func (r *Router) defaultNotAllowedMethod(w http.ResponseWriter, req *http.Request, _ *ParameterList) {
+	r.errorHandler(w, req, errMethodNotAllowed)
 }
 
 // default panic handler
-func defaultPanicHandler(w http.ResponseWriter, _ *http.Request, _ interface{}) {
-	w.WriteHeader(500)
-	fmt.Fprintf(w, "Something went wrong with your request")
+func (r *Router) defaultPanicHandler(w http.ResponseWriter, req *http.Request, report *PanicReport) {
+	if report.ResponseStarted {
+		return
+	}
+	r.errorHandler(w, req, &HTTPError{
+		Status:  http.StatusInternalServerError,
+		Message: "Something went wrong with your request",
+		Err:     fmt.Errorf("panic: %v", report.Value),
+	})
+}
+
+// default error handler, errors are rendered as problems
+func (r *Router) defaultErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	r.WriteError(w, req, err)
 }
@@ -0,0 +1,140 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorRequestHandler is a request handler that can return an error
+// returned errors are written to the user by the router error handler
+type ErrorRequestHandler func(http.ResponseWriter, *http.Request, *ParameterList) error
+
+// ErrorHandler is a direct function call that writes an error to the user
+type ErrorHandler func(http.ResponseWriter, *http.Request, error)
+
+// HTTPError is an error that carries the status code that should be sent to the user
+type HTTPError struct {
+	Status  int
+	Message string // message safe to show to the user, if empty the status text is used
+	Err     error  // cause of the error, never shown to the user
+}
+
+//*********************************************************************************************************************
+// HTTPError
+
+// Error implements the error interface
+func (e *HTTPError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the cause of the error
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// NewHTTPError creates an error with a status code and a message for the user
+func NewHTTPError(status int, message string) *HTTPError {
+	return &HTTPError{Status: status, Message: message}
+}
+
+// NotFoundError creates a 404 error
+func NotFoundError(message string) error {
+	return &HTTPError{Status: http.StatusNotFound, Message: message}
+}
+
+// ValidationError creates a 400 error for invalid user input
+func ValidationError(message string) error {
+	return &HTTPError{Status: http.StatusBadRequest, Message: message}
+}
+
+// ConflictError creates a 409 error
+func ConflictError(message string) error {
+	return &HTTPError{Status: http.StatusConflict, Message: message}
+}
+
+// UnauthorizedError creates a 401 error
+func UnauthorizedError(message string) error {
+	return &HTTPError{Status: http.StatusUnauthorized, Message: message}
+}
+
+// ForbiddenError creates a 403 error
+func ForbiddenError(message string) error {
+	return &HTTPError{Status: http.StatusForbidden, Message: message}
+}
+
+// ErrorStatus returns the status code of an error, errors that are not (or don't wrap) an HTTPError or a Problem are 500
+func ErrorStatus(err error) int {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status
+	}
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorMessage returns the message of an error that is safe to show to the user
+func ErrorMessage(err error) string {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.Message != "" {
+		return httpErr.Message
+	}
+	var problem *Problem
+	if errors.As(err, &problem) && problem.Detail != "" {
+		return problem.Detail
+	}
+	return http.StatusText(ErrorStatus(err))
+}
+
+//*********************************************************************************************************************
+
+// SetErrorHandler sets the function used to write errors returned by handlers
+// default not found, not allowed and panic pages are also written with this handler
+func (r *Router) SetErrorHandler(handler ErrorHandler) {
+	r.errorHandler = handler
+}
+
+// WrapErr converts an error returning handler into a normal handler that uses the router error handler
+// useful to combine error handlers with middlewares
+func (r *Router) WrapErr(handler ErrorRequestHandler) RequestHandler {
+	return func(w http.ResponseWriter, req *http.Request, p *ParameterList) {
+		if err := handler(w, req, p); err != nil {
+			// server errors are logged like panics, user errors are not
+			if ErrorStatus(err) >= 500 {
+				r.logger.Log(LevelError, "Handler error", "error", err, "method", req.Method,
+					"path", req.URL.Path, "route", p.Route().Pattern(), "request_id", RequestID(req))
+			}
+			r.errorHandler(w, req, err)
+		}
+	}
+}
+
+// HandleErr adds (or reset) a route with an error returning handler
+func (r *Router) HandleErr(method, path string, handler ErrorRequestHandler) *Route {
+	return r.Handle(method, path, r.WrapErr(handler))
+}
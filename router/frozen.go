@@ -0,0 +1,201 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import "strings"
+
+// node of a compiled tree, all the static children of a node are stored one after the other
+// ordered by (size, name) and followed by the parametric child if there is any
+// names are slices of the tree names so the search never follows a pointer to a separate string
+type frozenNode struct {
+	route      *Route
+	nameStart  int32 // offset of the name in frozenTree.names
+	nameSize   int32
+	firstChild int32
+	childCount int32 // static children only
+	param      int32 // index of the parametric child or -1
+}
+
+// frozenTree is a path tree compiled into a single contiguous slice of nodes, the root is the first element
+type frozenTree struct {
+	nodes []frozenNode
+	names string // names of all the nodes one after the other
+}
+
+//*********************************************************************************************************************
+// frozenTree
+
+// compile a path tree into a flat tree with a breadth first visit
+// the order of the visit guarantees that the nodes of the queue and of the tree share the same index
+func freezeTree(root *pathNode) *frozenTree {
+	var nodes []frozenNode
+	var names strings.Builder
+	var queue []*pathNode
+
+	add := func(node *pathNode) {
+		nodes = append(nodes, frozenNode{route: node.route, nameStart: int32(names.Len()), nameSize: int32(len(node.name))})
+		names.WriteString(node.name)
+		queue = append(queue, node)
+	}
+	add(root)
+
+	for i := 0; i < len(queue); i++ {
+		node := queue[i]
+		nodes[i].firstChild = int32(len(nodes))
+		nodes[i].param = -1
+
+		// containers are indexed by size and sorted by name so the children end up already sorted
+		for _, container := range node.staticRoutes {
+			for _, child := range container {
+				add(child)
+			}
+		}
+		nodes[i].childCount = int32(len(nodes)) - nodes[i].firstChild
+
+		if pn := node.parameterHandler; pn != nil {
+			nodes[i].param = int32(len(nodes))
+			add(pn)
+		}
+	}
+
+	// drop the extra capacity left by append
+	ft := &frozenTree{nodes: make([]frozenNode, len(nodes)), names: names.String()}
+	copy(ft.nodes, nodes)
+	return ft
+}
+
+// name of a node
+func (ft *frozenTree) name(node int32) string {
+	n := &ft.nodes[node]
+	return ft.names[n.nameStart : n.nameStart+n.nameSize]
+}
+
+// search a static child of a node with binary search, returns -1 if not found
+// sizes are compared first so names are read only for children of the same size
+func frozenChild(nodes []frozenNode, names string, node *frozenNode, subpath string) int32 {
+	size := int32(len(subpath))
+	low := node.firstChild
+	high := node.firstChild + node.childCount - 1
+
+	for low <= high {
+		mid := (low + high) / 2
+		n := &nodes[mid]
+		if n.nameSize != size {
+			if n.nameSize > size {
+				high = mid - 1
+			} else {
+				low = mid + 1
+			}
+			continue
+		}
+
+		// segments are short, a byte loop is faster than the runtime compare calls
+		name := names[n.nameStart : n.nameStart+size]
+		j := 0
+		for j < len(name) && name[j] == subpath[j] {
+			j++
+		}
+		if j == len(name) {
+			return mid
+		}
+		if subpath[j] < name[j] {
+			high = mid - 1
+		} else {
+			low = mid + 1
+		}
+	}
+
+	return -1
+}
+
+// walk the compiled tree and return the route that matches the url
+// this follows exactly the same rules of Router.lookupTree
+func (ft *frozenTree) lookup(url string, pool *ParametersPool) (*Route, *ParameterList) {
+	nodes, names := ft.nodes, ft.names
+	currentNode := &nodes[0]
+
+	// return index page
+	if len(url) == 0 || url == "/" {
+		if idx := frozenChild(nodes, names, currentNode, "/"); idx != -1 {
+			return nodes[idx].route, nil
+		}
+		if currentNode.param != -1 {
+			return nodes[currentNode.param].route, nil
+		}
+		return nil, nil
+	}
+
+	var parameters *ParameterList
+	lastSlash := 0
+	size := len(url)
+	var sch string
+
+	for i := 1; i < size; i++ {
+		if url[i] == '/' || i == size-1 {
+			if i != size-1 {
+				sch = url[lastSlash:i]
+			} else {
+				sch = url[lastSlash:]
+			}
+
+			if idx := frozenChild(nodes, names, currentNode, sch); idx != -1 {
+				currentNode = &nodes[idx]
+			} else if currentNode.param != -1 {
+				currentNode = &nodes[currentNode.param]
+				if parameters == nil {
+					parameters = pool.Get()
+				}
+				if name := names[currentNode.nameStart : currentNode.nameStart+currentNode.nameSize]; name == "*" {
+					parameters.Set(name, url[lastSlash:])
+					break
+				} else {
+					parameters.Set(name, sch[1:])
+				}
+			} else {
+				pool.Push(parameters)
+				return nil, nil
+			}
+
+			lastSlash = i
+		}
+	}
+
+	if currentNode.route == nil {
+		pool.Push(parameters)
+		return nil, nil
+	}
+	return currentNode.route, parameters
+}
+
+//*********************************************************************************************************************
+
+// Freeze compiles the path trees into flat tables that use less memory and are faster to search on large routers
+// call this once all the routes are registered: the original trees are released and adding a path
+// to a frozen router will panic
+func (r *Router) Freeze() {
+	if r.frozen {
+		return
+	}
+
+	for i, root := range r.pathTrees {
+		if root != nil {
+			r.frozenTrees[i] = freezeTree(root)
+			r.pathTrees[i] = nil
+		}
+	}
+	r.frozen = true
+}
@@ -0,0 +1,175 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the importance of a log message
+type LogLevel int
+
+// log levels from the least to the most important
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Logger is used by routers and other components to write their messages
+// fields are a list of key value pairs es: "method", "GET", "status", 405
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...interface{})
+}
+
+// NopLogger drops all the messages
+type NopLogger struct{}
+
+// writes messages with the standard log package as a line of key=value fields
+type stdLogger struct {
+	logger   *log.Logger
+	minLevel LogLevel
+}
+
+// writes messages as json lines
+type jsonLogger struct {
+	out      io.Writer
+	minLevel LogLevel
+	mutex    sync.Mutex
+}
+
+var defaultLogger Logger = &stdLogger{minLevel: LevelInfo}
+
+//*********************************************************************************************************************
+// LogLevel
+
+// String returns the name of the level
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+//*********************************************************************************************************************
+// loggers
+
+// Log implements Logger
+func (NopLogger) Log(LogLevel, string, ...interface{}) {}
+
+// NewStdLogger creates a logger that writes lines like "INFO msg key=value" to a standard logger
+// if logger is nil the output of the log package is used, messages below minLevel are dropped
+func NewStdLogger(logger *log.Logger, minLevel LogLevel) Logger {
+	return &stdLogger{logger: logger, minLevel: minLevel}
+}
+
+func (sl *stdLogger) Log(level LogLevel, msg string, fields ...interface{}) {
+	if level < sl.minLevel {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		sb.WriteByte(' ')
+		sb.WriteString(fmt.Sprint(fields[i]))
+		sb.WriteByte('=')
+		if i+1 < len(fields) {
+			value := fmt.Sprint(fields[i+1])
+			if strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			sb.WriteString(value)
+		}
+	}
+
+	if sl.logger != nil {
+		sl.logger.Print(sb.String())
+	} else {
+		log.Print(sb.String())
+	}
+}
+
+// NewJSONLogger creates a logger that writes a json object for every message
+// with time, level, msg and all the fields, messages below minLevel are dropped
+func NewJSONLogger(out io.Writer, minLevel LogLevel) Logger {
+	return &jsonLogger{out: out, minLevel: minLevel}
+}
+
+func (jl *jsonLogger) Log(level LogLevel, msg string, fields ...interface{}) {
+	if level < jl.minLevel {
+		return
+	}
+
+	entry := make(map[string]interface{}, 3+len(fields)/2)
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	for i := 0; i < len(fields); i += 2 {
+		var value interface{}
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		// errors and other values without exported fields would be written as {}
+		switch v := value.(type) {
+		case error:
+			value = v.Error()
+		case fmt.Stringer:
+			value = v.String()
+		}
+		entry[fmt.Sprint(fields[i])] = value
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": level.String(), "msg": msg, "error": err.Error()})
+	}
+	line = append(line, '\n')
+
+	jl.mutex.Lock()
+	jl.out.Write(line)
+	jl.mutex.Unlock()
+}
+
+//*********************************************************************************************************************
+
+// DefaultLogger returns the logger used when none is set: INFO and above written with the log package
+func DefaultLogger() Logger {
+	return defaultLogger
+}
+
+// SetLogger sets the logger used by the router for its messages, pass NopLogger{} to silence it
+func (r *Router) SetLogger(logger Logger) {
+	r.logger = logger
+}
@@ -0,0 +1,65 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"net/http"
+)
+
+// PanicReport describes a panic received by the router
+type PanicReport struct {
+	Value     interface{} // value passed to panic
+	Stack     []byte      // stack trace of the goroutine that panicked
+	Route     *Route      // matched route, nil if the panic happened before a route was found
+	RequestID string
+	// ResponseStarted is true if the handler already wrote headers or part of the body
+	// in this case the writer passed to the panic handler drops everything and the connection is aborted
+	ResponseStarted bool
+}
+
+// ForwardedPanic carries a panic from the goroutine where it happened to the one of the router
+// middlewares that run handlers in their own goroutine re-panic with it, the router reports Value and Stack
+// as if the handler had panicked in its goroutine
+type ForwardedPanic struct {
+	Value interface{}
+	Stack []byte
+}
+
+type requestIDKey struct{}
+
+// Pattern returns the pattern of the route that panicked or an empty string
+func (pr *PanicReport) Pattern() string {
+	if pr.Route == nil {
+		return ""
+	}
+	return pr.Route.Pattern()
+}
+
+//*********************************************************************************************************************
+
+// WithRequestID returns a copy of the request that carries a request id
+// the id is added to panic reports and can be read by handlers with RequestID
+func WithRequestID(req *http.Request, id string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// RequestID returns the id of the request or an empty string if none is set
+func RequestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
@@ -27,9 +27,11 @@ type Parameter struct {
 }
 
 // ParameterList is a list of parameters
+// it also holds the route matched by the router
 type ParameterList struct {
-	data []Parameter
-	size int
+	data  []Parameter
+	size  int
+	route *Route
 }
 
 // ParametersPool is an allocation pool for paramters to keep allocated and not used paramters blocks
@@ -63,6 +65,25 @@ func (pl *ParameterList) Get(key string) string {
 	return ""
 }
 
+// Route returns the route matched by the router, nil if the list is nil or not created by a router
+func (pl *ParameterList) Route() *Route {
+	if pl == nil {
+		return nil
+	}
+	return pl.route
+}
+
+// Clone returns a copy of the list that is not owned by the router pool
+// use it when parameters must be read after the handler returns (es: in another goroutine)
+func (pl *ParameterList) Clone() *ParameterList {
+	if pl == nil {
+		return nil
+	}
+	c := &ParameterList{data: make([]Parameter, pl.size), size: pl.size, route: pl.route}
+	copy(c.data, pl.data[:pl.size])
+	return c
+}
+
 //*********************************************************************************************************************
 // ParamterPool
 
@@ -84,13 +105,17 @@ func (pp *ParametersPool) Init(maxParameters, size, maxSize int) {
 // Get a parameter array from the pool (or allocate a new one if none is available)
 func (pp *ParametersPool) Get() *ParameterList {
 	// first give a pre allocated values if there is any
-	if pp.paramStack != nil && pp.currentSize > 0 {
+	if pp.mutex != nil {
 		pp.mutex.Lock()
-		r := pp.paramStack[pp.currentSize-1]
-		r.size = 0
-		pp.currentSize--
+		if pp.currentSize > 0 {
+			r := pp.paramStack[pp.currentSize-1]
+			pp.currentSize--
+			pp.mutex.Unlock()
+			r.size = 0
+			r.route = nil
+			return r
+		}
 		pp.mutex.Unlock()
-		return r
 	}
 
 	return &ParameterList{data: make([]Parameter, pp.maxParameters)}
@@ -99,11 +124,19 @@ func (pp *ParametersPool) Get() *ParameterList {
 // Push readds a paramter list to the pool, this will work until maxSize is reached
 // then all the pushed values will be deleted by the garbage collector
 func (pp *ParametersPool) Push(pl *ParameterList) {
+	// lists allocated before a resize of the pool are too small to be reused
+	if pl == nil || pp.mutex == nil || len(pl.data) < pp.maxParameters {
+		return
+	}
 
-	if pl != nil && pp.currentSize < pp.maxSize {
-		pp.mutex.Lock()
-		pp.paramStack[pp.currentSize] = pl
+	pp.mutex.Lock()
+	if pp.currentSize < pp.maxSize {
+		if pp.currentSize < len(pp.paramStack) {
+			pp.paramStack[pp.currentSize] = pl
+		} else {
+			pp.paramStack = append(pp.paramStack, pl)
+		}
 		pp.currentSize++
-		pp.mutex.Unlock()
 	}
+	pp.mutex.Unlock()
 }
@@ -0,0 +1,152 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Problem is an error description as defined by RFC 7807
+// a problem is also an error so it can be returned directly by error handlers
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ProblemRenderer is a direct function call that writes a problem to the user
+type ProblemRenderer func(http.ResponseWriter, *http.Request, *Problem)
+
+//*********************************************************************************************************************
+// Problem
+
+// Error implements the error interface
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+// ProblemFromError builds the problem that describes an error
+// the detail is set only if the error has a message for the user
+func ProblemFromError(err error, req *http.Request) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		res := *problem
+		if res.Instance == "" && req != nil {
+			res.Instance = req.URL.Path
+		}
+		return &res
+	}
+
+	status := ErrorStatus(err)
+	res := &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		res.Detail = httpErr.Message
+	}
+	if req != nil {
+		res.Instance = req.URL.Path
+	}
+	return res
+}
+
+// find the best quality of a list of media types in an accept header
+func acceptQuality(accept string, types ...string) float64 {
+	best := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		media := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		for _, t := range types {
+			if media == t && q > best {
+				best = q
+			}
+		}
+	}
+	return best
+}
+
+// PrefersJSON checks if the Accept header of a request rates a json response higher than plain text
+// an empty or generic header (es: */*) falls back to plain text
+func PrefersJSON(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	jsonQ := acceptQuality(accept, "application/problem+json", "application/json", "application/*")
+	textQ := acceptQuality(accept, "text/plain", "text/*", "*/*")
+	return jsonQ > textQ
+}
+
+// RenderProblem writes a problem as application/problem+json or as plain text based on the Accept header
+// plain text responces contain only the detail or the title if there is no detail
+func RenderProblem(w http.ResponseWriter, req *http.Request, p *Problem) {
+	if PrefersJSON(req) {
+		body, err := json.Marshal(p)
+		if err == nil {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(p.Status)
+			w.Write(body)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(p.Status)
+	if p.Detail != "" {
+		fmt.Fprint(w, p.Detail)
+	} else {
+		fmt.Fprint(w, p.Title)
+	}
+}
+
+//*********************************************************************************************************************
+
+// SetProblemRenderer sets the function used by the default error handler to write errors
+func (r *Router) SetProblemRenderer(renderer ProblemRenderer) {
+	r.problemRenderer = renderer
+}
+
+// WriteProblem writes a problem with the renderer of the router
+// custom handlers can use this to send errors with the same format of the router pages
+func (r *Router) WriteProblem(w http.ResponseWriter, req *http.Request, p *Problem) {
+	r.problemRenderer(w, req, p)
+}
+
+// WriteError writes an error as a problem with the renderer of the router
+func (r *Router) WriteError(w http.ResponseWriter, req *http.Request, err error) {
+	r.problemRenderer(w, req, ProblemFromError(err, req))
+}
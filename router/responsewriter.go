@@ -0,0 +1,182 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ResponseWriter wraps an http.ResponseWriter to track the status code, the size of the body and if the responce
+// is already started. Optional interfaces of the original writer (http.Flusher, http.Hijacker, http.Pusher and
+// io.ReaderFrom) are forwarded and Unwrap lets http.ResponseController reach the original writer
+type ResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// wrapper used by the router to know if the responce is already started when a panic is received
+type responseTracker struct {
+	ResponseWriter
+	route *Route
+}
+
+// writer given to the panic handler when the responce is already started, everything is dropped
+type nopResponseWriter struct {
+	header http.Header
+}
+
+var trackerPool = sync.Pool{New: func() interface{} { return &responseTracker{} }}
+
+//*********************************************************************************************************************
+// ResponseWriter
+
+// WrapResponseWriter creates a wrapper for a writer
+func WrapResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+// Status returns the status code sent to the user
+// if nothing is written yet this is 200 because the server sends it when the handler returns
+func (rw *ResponseWriter) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// BytesWritten returns the size of the body written until now
+func (rw *ResponseWriter) BytesWritten() int64 {
+	return rw.bytes
+}
+
+// HeaderWritten checks if the responce is started (headers sent, body written, flushed or connection hijacked)
+func (rw *ResponseWriter) HeaderWritten() bool {
+	return rw.wroteHeader
+}
+
+// WriteHeader implements http.ResponseWriter
+func (rw *ResponseWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.status = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (rw *ResponseWriter) Write(b []byte) (int, error) {
+	// the std server sends 200 if the header is not written before the body
+	if !rw.wroteHeader {
+		rw.status = http.StatusOK
+		rw.wroteHeader = true
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, it does nothing if the original writer can't flush
+func (rw *ResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if !rw.wroteHeader {
+			rw.status = http.StatusOK
+			rw.wroteHeader = true
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		conn, buf, err := h.Hijack()
+		if err == nil {
+			rw.wroteHeader = true
+		}
+		return conn, buf, err
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push implements http.Pusher
+func (rw *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := rw.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// ReadFrom implements io.ReaderFrom to keep sendfile optimizations
+func (rw *ResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !rw.wroteHeader {
+		rw.status = http.StatusOK
+		rw.wroteHeader = true
+	}
+
+	var n int64
+	var err error
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		// hide ReadFrom of the wrapper to avoid an infinite loop
+		n, err = io.Copy(struct{ io.Writer }{rw.ResponseWriter}, src)
+	}
+	rw.bytes += n
+	return n, err
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+//*********************************************************************************************************************
+// responseTracker
+
+func getTracker(w http.ResponseWriter) *responseTracker {
+	rt := trackerPool.Get().(*responseTracker)
+	rt.ResponseWriter = ResponseWriter{ResponseWriter: w}
+	return rt
+}
+
+func putTracker(rt *responseTracker) {
+	rt.ResponseWriter = ResponseWriter{}
+	rt.route = nil
+	trackerPool.Put(rt)
+}
+
+//*********************************************************************************************************************
+// nopResponseWriter
+
+func (nw *nopResponseWriter) Header() http.Header {
+	if nw.header == nil {
+		nw.header = http.Header{}
+	}
+	return nw.header
+}
+
+func (nw *nopResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (nw *nopResponseWriter) WriteHeader(int) {}
@@ -0,0 +1,211 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Metadata is a set of informations attached to a route when it's registered
+// middlewares and tools can read it at request time (ParameterList.Route) or with Router.Routes
+type Metadata struct {
+	Summary    string
+	Tags       []string
+	Scopes     []string  // auth scopes required by the route
+	RateLimit  string    // rate limit class
+	Owner      string    // team that owns the route
+	Deprecated time.Time // zero if the route is not deprecated
+	Extra      map[string]interface{}
+}
+
+// Route is a path registered in a router
+type Route struct {
+	Method   string
+	Path     string // path relative to the router prefix
+	Metadata Metadata
+	handler  RequestHandler
+	pattern  string
+}
+
+// RouteTracker records the route matched by any router that handles the request
+// it's used by middlewares that wrap a whole router and can't see the ParameterList
+type RouteTracker struct {
+	route *Route
+}
+
+type routeTrackerKey struct{}
+
+//*********************************************************************************************************************
+// Metadata
+
+// HasTag checks if a tag is set in the metadata
+func (m *Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns a custom value or nil if not set
+func (m *Metadata) Get(key string) interface{} {
+	return m.Extra[key]
+}
+
+// IsDeprecated checks if the deprecation date is set and already passed
+func (m *Metadata) IsDeprecated(now time.Time) bool {
+	return !m.Deprecated.IsZero() && !now.Before(m.Deprecated)
+}
+
+//*********************************************************************************************************************
+// Route
+
+// Pattern returns the full path pattern of the route including the router prefix (es: /api/users/:id)
+// this is meant to be used for logs and metrics in place of the raw url, a nil route has an empty pattern
+func (rt *Route) Pattern() string {
+	if rt == nil {
+		return ""
+	}
+	return rt.pattern
+}
+
+// build the full pattern of a route path
+func joinPattern(prefix, path string) string {
+	if prefix != "" && path == "/" {
+		return prefix
+	}
+	return prefix + path
+}
+
+// WithMetadata replaces the metadata of the route
+func (rt *Route) WithMetadata(meta Metadata) *Route {
+	rt.Metadata = meta
+	return rt
+}
+
+// WithSummary sets a short description of the route
+func (rt *Route) WithSummary(summary string) *Route {
+	rt.Metadata.Summary = summary
+	return rt
+}
+
+// WithTags adds tags to the route
+func (rt *Route) WithTags(tags ...string) *Route {
+	rt.Metadata.Tags = append(rt.Metadata.Tags, tags...)
+	return rt
+}
+
+// WithScopes adds auth scopes required by the route
+func (rt *Route) WithScopes(scopes ...string) *Route {
+	rt.Metadata.Scopes = append(rt.Metadata.Scopes, scopes...)
+	return rt
+}
+
+// WithValue sets a custom value in the metadata
+func (rt *Route) WithValue(key string, value interface{}) *Route {
+	if rt.Metadata.Extra == nil {
+		rt.Metadata.Extra = make(map[string]interface{})
+	}
+	rt.Metadata.Extra[key] = value
+	return rt
+}
+
+//*********************************************************************************************************************
+// RouteTracker
+
+// TrackRoute returns a copy of the request with a tracker that will be filled with the matched route
+// once the request is handled by a router
+func TrackRoute(req *http.Request) (*http.Request, *RouteTracker) {
+	tracker := &RouteTracker{}
+	return req.WithContext(context.WithValue(req.Context(), routeTrackerKey{}, tracker)), tracker
+}
+
+// Route returns the matched route or nil if no route was matched (yet)
+func (rt *RouteTracker) Route() *Route {
+	return rt.route
+}
+
+// Pattern returns the pattern of the matched route or an empty string
+func (rt *RouteTracker) Pattern() string {
+	if rt.route == nil {
+		return ""
+	}
+	return rt.route.pattern
+}
+
+// MatchedRoute returns the route matched for a request tracked with TrackRoute
+func MatchedRoute(req *http.Request) *Route {
+	if tracker, ok := req.Context().Value(routeTrackerKey{}).(*RouteTracker); ok {
+		return tracker.route
+	}
+	return nil
+}
+
+// save the route in the request tracker if there is one
+func trackMatchedRoute(req *http.Request, route *Route) {
+	if tracker, ok := req.Context().Value(routeTrackerKey{}).(*RouteTracker); ok {
+		tracker.route = route
+	}
+}
+
+//*********************************************************************************************************************
+
+// collect all the routes of a tree
+func collectRoutesTree(node *pathNode, out []*Route) []*Route {
+	if node.route != nil {
+		out = append(out, node.route)
+	}
+	for _, container := range node.staticRoutes {
+		for _, child := range container {
+			out = collectRoutesTree(child, out)
+		}
+	}
+	if node.parameterHandler != nil {
+		out = collectRoutesTree(node.parameterHandler, out)
+	}
+	return out
+}
+
+// Routes returns all the registered routes sorted by path and method
+func (r *Router) Routes() []*Route {
+	var routes []*Route
+
+	for i := 0; i < httpTotalMethods; i++ {
+		if r.frozen && r.frozenTrees[i] != nil {
+			for _, node := range r.frozenTrees[i].nodes {
+				if node.route != nil {
+					routes = append(routes, node.route)
+				}
+			}
+		} else if r.pathTrees[i] != nil {
+			routes = collectRoutesTree(r.pathTrees[i], routes)
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
@@ -17,8 +17,8 @@
 package router
 
 import (
-	"log"
 	"net/http"
+	"runtime/debug"
 	"strings"
 )
 
@@ -38,8 +38,8 @@ const allocationIncrement = 5
 // RequestHandler is a direct function call that handles a http request
 type RequestHandler func(http.ResponseWriter, *http.Request, *ParameterList)
 
-// PanicHandlerr is a direct function call that writes an error to the user when a panic is received (last parameter)
-type PanicHandler func(http.ResponseWriter, *http.Request, interface{})
+// PanicHandler is a direct function call that writes an error to the user when a panic is received (last parameter)
+type PanicHandler func(http.ResponseWriter, *http.Request, *PanicReport)
 
 // contrainer of a order list of path nodes all with the same size
 type pathContainer []*pathNode
@@ -49,7 +49,7 @@ type pathContainer []*pathNode
 type pathNode struct {
 	staticRoutes     []pathContainer
 	parameterHandler *pathNode
-	handler          RequestHandler
+	route            *Route
 	name             string
 }
 
@@ -61,9 +61,16 @@ type Router struct {
 	notFound         RequestHandler
 	notAllowedMethod RequestHandler
 	panicHandler     PanicHandler
+	errorHandler     ErrorHandler
+	problemRenderer  ProblemRenderer
+	logger           Logger
 	maxParamters     int
 	paramPool        ParametersPool
 	prefix           string
+	frozen           bool
+	frozenTrees      [httpTotalMethods]*frozenTree
+	useStaticCache   bool
+	staticCache      [httpTotalMethods]map[string]*Route
 }
 
 //*********************************************************************************************************************
@@ -136,6 +143,14 @@ func setStaticSubnode(currentNode *pathNode, relativePath string) *pathNode {
 	return currentNode.staticRoutes[subSize].get(relativePath)
 }
 
+// get an existing static subnode or nil
+func (pn *pathNode) getStatic(relativePath string) *pathNode {
+	if len(relativePath) >= len(pn.staticRoutes) || pn.staticRoutes[len(relativePath)] == nil {
+		return nil
+	}
+	return pn.staticRoutes[len(relativePath)].get(relativePath)
+}
+
 // add a parametric subnode and return a pointer to the new "current node"
 // generated by this function
 func setParametricSubNode(currentNode *pathNode, path, paramaterName string) *pathNode {
@@ -150,13 +165,61 @@ func setParametricSubNode(currentNode *pathNode, path, paramaterName string) *pa
 	return currentNode.parameterHandler
 }
 
+// find the node of a registered path without creating missing nodes, returns nil if the path is not in the tree
+// static is true when the path has no parameters
+func findNode(currentNode *pathNode, path string) (node *pathNode, static bool) {
+	if currentNode == nil {
+		return nil, false
+	}
+	if path == "/" {
+		return currentNode.getStatic("/"), true
+	}
+
+	static = true
+	lastSlash := 0
+	pathSize := len(path)
+
+	for i := 1; i < pathSize && currentNode != nil; i++ {
+		if path[i] == '/' || i == pathSize-1 {
+			var sch string
+			if i != pathSize-1 {
+				sch = path[lastSlash:i]
+			} else {
+				sch = path[lastSlash:]
+			}
+
+			if len(sch) > 2 && sch[0:2] == "/:" {
+				static = false
+				if currentNode.parameterHandler != nil && currentNode.parameterHandler.name != sch[2:] {
+					return nil, false
+				}
+				currentNode = currentNode.parameterHandler
+			} else {
+				currentNode = currentNode.getStatic(sch)
+			}
+			lastSlash = i
+		}
+	}
+
+	return currentNode, static
+}
+
 // generate a tree from a path and a method
-func (r *Router) setPath(method int, path string, handler RequestHandler) {
+func (r *Router) setPath(method int, path string, handler RequestHandler) *Route {
 
 	if method == -1 {
 		panic("Unsupported method for path " + path)
 	}
 
+	if r.frozen {
+		panic("Can't add path " + path + " to a frozen router")
+	}
+
+	var route *Route
+	if handler != nil {
+		route = &Route{Method: methodNames[method], Path: path, handler: handler, pattern: joinPattern(r.prefix, path)}
+	}
+
 	currentNode := r.pathTrees[method]
 
 	// create first node if not exist
@@ -167,8 +230,9 @@ func (r *Router) setPath(method int, path string, handler RequestHandler) {
 
 	if path == "/" {
 		currentNode = setStaticSubnode(currentNode, "/")
-		currentNode.handler = handler
-		return
+		currentNode.route = route
+		r.updateStaticCache(method, path, route)
+		return route
 	}
 
 	lastSlash := 0
@@ -198,7 +262,7 @@ func (r *Router) setPath(method int, path string, handler RequestHandler) {
 
 			// time to assign the handler only to last node
 			if i == pathSize-1 {
-				currentNode.handler = handler
+				currentNode.route = route
 			}
 			lastSlash = i
 		}
@@ -209,54 +273,31 @@ func (r *Router) setPath(method int, path string, handler RequestHandler) {
 		r.paramPool.Init(paramCount, poolSize, maxPoolSize)
 	}
 
-}
-
-// parse a request url and call the right handler
-func (r *Router) executeHandler(w http.ResponseWriter, req *http.Request) {
-
-	var url string
-	// dont jump away from function if not necessary
-	if r.prefix != "" {
-		url = strings.TrimPrefix(req.URL.Path, r.prefix)
-	} else {
-		url = req.URL.Path
+	if paramCount == 0 {
+		r.updateStaticCache(method, path, route)
 	}
 
-	method := methodToInt(req.Method)
-	var parameters *ParameterList
+	return route
+}
 
-	if method == -1 {
-		r.notAllowedMethod(w, req, nil)
-		log.Println("Method not allowed: " + req.Method)
-		return
-	}
-
-	currentNode := r.pathTrees[method]
-	// check if there is an handler for the request method
-	if currentNode == nil {
-		r.notAllowedMethod(w, req, nil)
-		log.Println("Method not allowed, no handler set for: " + req.Method)
-		return
-	}
+// walk the tree of a method and return the route that matches the url
+// parameters are taken from the pool only when needed and are already pushed back if no route is found
+func (r *Router) lookupTree(currentNode *pathNode, url string) (*Route, *ParameterList) {
 
 	// return index page
 	if len(url) == 0 || url == "/" {
-		staticNode := currentNode.staticRoutes[1]
-
-		if staticNode != nil {
-			ex := staticNode.get("/")
-			if ex != nil {
-				ex.handler(w, req, nil)
-				return
+		if len(currentNode.staticRoutes) > 1 {
+			if ex := currentNode.staticRoutes[1].get("/"); ex != nil {
+				return ex.route, nil
 			}
-		} else if pn := currentNode.parameterHandler; pn != nil { // paramter node is set
-			pn.handler(w, req, nil)
-			return
 		}
-		r.notFound(w, req, nil)
-		return
+		if pn := currentNode.parameterHandler; pn != nil { // paramter node is set
+			return pn.route, nil
+		}
+		return nil, nil
 	}
 
+	var parameters *ParameterList
 	lastSlash := 0
 	// start from one to skip the first /
 	size := len(url)
@@ -274,15 +315,8 @@ func (r *Router) executeHandler(w http.ResponseWriter, req *http.Request) {
 
 			// first search static nodes
 			var staticNode *pathNode
-			if currentNode.staticRoutes != nil {
-				sz := len(sch)
-				if sz < len(currentNode.staticRoutes) {
-					staticNode = currentNode.staticRoutes[sz].get(sch)
-				} else {
-					staticNode = nil
-				}
-			} else {
-				staticNode = nil
+			if sz := len(sch); sz < len(currentNode.staticRoutes) {
+				staticNode = currentNode.staticRoutes[sz].get(sch)
 			}
 
 			if staticNode != nil {
@@ -298,22 +332,79 @@ func (r *Router) executeHandler(w http.ResponseWriter, req *http.Request) {
 				} else {
 					parameters.Set(currentNode.name, sch[1:])
 				}
-			} else { // in nothing is found then we can print a not found message
+			} else { // in nothing is found then there is no possible match
 				r.paramPool.Push(parameters)
-				r.notFound(w, req, nil) // not found any possible match
-				return
+				return nil, nil
 			}
 
 			lastSlash = i // update last slash pos after operations
 		}
 	}
 
-	// when we are here we are in the last node of the url so we can execute the action
-	if currentNode.handler != nil {
-		currentNode.handler(w, req, parameters)
+	if currentNode.route == nil {
+		r.paramPool.Push(parameters)
+		return nil, nil
+	}
+	return currentNode.route, parameters
+}
+
+// parse a request url and call the right handler
+func (r *Router) executeHandler(w *responseTracker, req *http.Request) {
+
+	var url string
+	// dont jump away from function if not necessary
+	if r.prefix != "" {
+		url = strings.TrimPrefix(req.URL.Path, r.prefix)
 	} else {
-		r.notFound(w, req, nil)
+		url = req.URL.Path
+	}
+
+	method := methodToInt(req.Method)
+
+	if method == -1 {
+		r.notAllowedMethod(w, req, nil)
+		r.logger.Log(LevelInfo, "Method not allowed", "method", req.Method, "path", req.URL.Path)
+		return
+	}
+
+	var route *Route
+	var parameters *ParameterList
+
+	// fully static urls skip the tree walk
+	if cache := r.staticCache[method]; cache != nil {
+		route = cache[url]
+	}
+
+	if route == nil {
+		// check if there is an handler for the request method
+		if (r.frozen && r.frozenTrees[method] == nil) || (!r.frozen && r.pathTrees[method] == nil) {
+			r.notAllowedMethod(w, req, nil)
+			r.logger.Log(LevelInfo, "Method not allowed, no handler set", "method", req.Method, "path", req.URL.Path)
+			return
+		}
+
+		if r.frozen {
+			route, parameters = r.frozenTrees[method].lookup(url, &r.paramPool)
+		} else {
+			route, parameters = r.lookupTree(r.pathTrees[method], url)
+		}
+
+		if route == nil {
+			r.notFound(w, req, nil) // not found any possible match
+			return
+		}
+	}
+
+	// handlers always get a parameter list so they can reach the matched route
+	if parameters == nil {
+		parameters = r.paramPool.Get()
 	}
+	parameters.route = route
+	w.route = route
+	trackMatchedRoute(req, route)
+
+	// when we are here we are in the last node of the url so we can execute the action
+	route.handler(w, req, parameters)
 	r.paramPool.Push(parameters)
 }
 
@@ -321,7 +412,13 @@ func (r *Router) executeHandler(w http.ResponseWriter, req *http.Request) {
 
 // MakeRouter creates a new router with no middleware and with default index and error pages
 func MakeRouter() *Router {
-	r := &Router{notFound: defaultFallback, notAllowedMethod: defaultNotAllowedMethod, prefix: "", panicHandler: defaultPanicHandler}
+	r := &Router{prefix: "", problemRenderer: RenderProblem, logger: defaultLogger}
+	r.errorHandler = r.defaultErrorHandler
+	r.notFound = r.defaultFallback
+	r.notAllowedMethod = r.defaultNotAllowedMethod
+	r.panicHandler = r.defaultPanicHandler
+	// every matched route takes a list from the pool, even if it has no parameters
+	r.paramPool.Init(0, poolSize, maxPoolSize)
 	return r
 }
 
@@ -340,51 +437,104 @@ func (r *Router) SetPanicHandler(handler PanicHandler) {
 	r.panicHandler = handler
 }
 
-// Handle adds (or reset) a route handler
-func (r *Router) Handle(method, path string, handler RequestHandler) {
-	r.setPath(methodToInt(method), path, handler)
+// Handle adds (or reset) a route handler and returns the new route so metadata can be attached to it
+func (r *Router) Handle(method, path string, handler RequestHandler) *Route {
+	return r.setPath(methodToInt(method), path, handler)
+}
+
+// Remove deletes the handler of a route, the route will answer with the not found page
+// unknown paths are ignored, removing a path from a frozen router will panic
+func (r *Router) Remove(method, path string) {
+	m := methodToInt(method)
+	if m == -1 {
+		panic("Unsupported method for path " + path)
+	}
+	if r.frozen {
+		panic("Can't remove path " + path + " from a frozen router")
+	}
+
+	node, static := findNode(r.pathTrees[m], path)
+	if node == nil || node.route == nil {
+		return
+	}
+	node.route = nil
+	if static {
+		r.updateStaticCache(m, path, nil)
+	}
 }
 
 // GET sets a request handler for the specified url only for GET requests
 // this is equivalent to call Handle("GET", ...)
-func (r *Router) GET(path string, handler RequestHandler) {
-	r.setPath(httpGET, path, handler)
+func (r *Router) GET(path string, handler RequestHandler) *Route {
+	return r.setPath(httpGET, path, handler)
 }
 
 // POST sets a request handler for the specified url only for POST requests
 // this is equivalent to call Handle("POST", ...)
-func (r *Router) POST(path string, handler RequestHandler) {
-	r.setPath(httpPOST, path, handler)
+func (r *Router) POST(path string, handler RequestHandler) *Route {
+	return r.setPath(httpPOST, path, handler)
 }
 
 // PATCH sets a request handler for the specified url only for PATCH requests
 // this is equivalent to call Handle("PATCH", ...)
-func (r *Router) PATCH(path string, handler RequestHandler) {
-	r.setPath(httpPATCH, path, handler)
+func (r *Router) PATCH(path string, handler RequestHandler) *Route {
+	return r.setPath(httpPATCH, path, handler)
 }
 
 // PUT sets a request handler for the specified url only forPUT requests
 // this is equivalent to call Handle("PUT", ...)
-func (r *Router) PUT(path string, handler RequestHandler) {
-	r.setPath(httpPUT, path, handler)
+func (r *Router) PUT(path string, handler RequestHandler) *Route {
+	return r.setPath(httpPUT, path, handler)
 }
 
 // DELETE sets a request handler for the specified url only for DELETE requests
 // this is equivalent to call Handle("DELETE", ...)
-func (r *Router) DELETE(path string, handler RequestHandler) {
-	r.setPath(httpDELETE, path, handler)
+func (r *Router) DELETE(path string, handler RequestHandler) *Route {
+	return r.setPath(httpDELETE, path, handler)
 }
 
 // ServeHTTP implements http.handler interface to allow this router to be easly used with std server
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	tracker := getTracker(w)
+
 	defer func() {
 		if err := recover(); err != nil {
-			log.Print(err)
-			r.panicHandler(w, req, err)
+			stack := debug.Stack()
+			if fp, ok := err.(*ForwardedPanic); ok {
+				err, stack = fp.Value, fp.Stack
+			}
+
+			// used by handlers to abort the connection on purpose, leave it to the server
+			if err == http.ErrAbortHandler {
+				putTracker(tracker)
+				panic(err)
+			}
+
+			report := &PanicReport{
+				Value:           err,
+				Stack:           stack,
+				Route:           tracker.route,
+				RequestID:       RequestID(req),
+				ResponseStarted: tracker.HeaderWritten(),
+			}
+			r.logger.Log(LevelError, "Panic while handling request", "panic", err, "method", req.Method,
+				"path", req.URL.Path, "route", report.Pattern(), "request_id", report.RequestID, "stack", string(report.Stack))
+
+			if !report.ResponseStarted {
+				r.panicHandler(tracker, req, report)
+				putTracker(tracker)
+				return
+			}
+
+			// a clean error can't be sent to the user so the connection is closed
+			r.panicHandler(&nopResponseWriter{}, req, report)
+			putTracker(tracker)
+			panic(http.ErrAbortHandler)
 		}
+		putTracker(tracker)
 	}()
 
-	r.executeHandler(w, req)
+	r.executeHandler(tracker, req)
 }
 
 // UsePrefix set a path prefix that should be removed before parsing the request
@@ -393,4 +543,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // /api/endpoint becomes /endpoint!
 func (r *Router) UsePrefix(prefix string) {
 	r.prefix = prefix
+	for _, route := range r.Routes() {
+		route.pattern = joinPattern(prefix, route.Path)
+	}
 }
@@ -17,10 +17,14 @@
 package router
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -168,3 +172,354 @@ func TestPrefix(t *testing.T) {
 	RunRequest(router, "GET", "/a", 200, "hello", t)
 	RunRequest(router, "GET", "/api/a", 200, "hello", t)
 }
+
+func TestFrozenRoutes(t *testing.T) {
+	router := MakeRouter()
+	router.GET("/", printHello)
+	router.GET("/static/path/to/hello", printHello)
+	router.GET("/static/path/hello.html", printHello)
+	router.GET("/activity/:user", writeData)
+	router.GET("/activity/:user/:activity/comments/:comment", writeData)
+	router.GET("/files/:*", fw)
+	router.POST("/activity/:user/:activity", writeData)
+	router.Freeze()
+
+	RunRequest(router, "GET", "/", 200, "hello", t)
+	RunRequest(router, "GET", "/static/path/to/hello", 200, "hello", t)
+	RunRequest(router, "GET", "/static/path/to/hello.html", 404, "Not Found", t)
+	RunRequest(router, "GET", "/static/path/hello.html", 200, "hello", t)
+	RunRequest(router, "GET", "/activity/raccoon", 200, "raccoon--", t)
+	RunRequest(router, "POST", "/activity/raccoon/123", 200, "raccoon-123-", t)
+	RunRequest(router, "GET", "/activity/raccoon/123/comments/456", 200, "raccoon-123-456", t)
+	RunRequest(router, "GET", "/activity/raccoon/123", 404, "Not Found", t)
+	RunRequest(router, "GET", "/files/a/b.txt", 200, "Got: /a/b.txt", t)
+	RunRequest(router, "GET", "/activity", 404, "Not Found", t)
+	RunRequest(router, "PUT", "/activity/123", 405, "Method Not Allowed", t)
+}
+
+func TestPanicOnFrozenChange(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("Should panic when a path is added to a frozen router")
+		}
+	}()
+
+	router := MakeRouter()
+	router.GET("/a", printHello)
+	router.Freeze()
+	router.GET("/b", printHello)
+}
+
+func TestRemoveUnknownPath(t *testing.T) {
+	router := MakeRouter()
+	router.GET("/users/:user", writeData)
+	root := router.pathTrees[httpGET]
+	children := len(root.staticRoutes)
+
+	router.Remove("GET", "/not/registered/path")
+	router.Remove("GET", "/users/:id")
+	router.Remove("POST", "/users/:user")
+	if len(root.staticRoutes) != children || root.getStatic("/not") != nil || router.pathTrees[httpPOST] != nil {
+		t.Errorf("Removing an unknown path created nodes")
+	}
+	RunRequest(router, "GET", "/users/raccoon", 200, "raccoon--", t)
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "remove") {
+			t.Errorf("Wrong panic removing from a frozen router: %v", r)
+		}
+	}()
+	router.Freeze()
+	router.Remove("GET", "/users/:user")
+}
+
+func TestStaticCache(t *testing.T) {
+	router := MakeRouter()
+	router.GET("/", printHello)
+	router.GET("/static/path/to/hello", printHello)
+	router.GET("/activity/:user", writeData)
+	router.EnableStaticCache()
+	router.GET("/late", printMethod)
+	router.UsePrefix("/api")
+
+	RunRequest(router, "GET", "/api", 200, "hello", t)
+	RunRequest(router, "GET", "/api/static/path/to/hello", 200, "hello", t)
+	RunRequest(router, "GET", "/api/late", 200, "GET", t)
+	RunRequest(router, "GET", "/api/activity/raccoon", 200, "raccoon--", t)
+
+	router.Remove("GET", "/static/path/to/hello")
+	RunRequest(router, "GET", "/api/static/path/to/hello", 404, "Not Found", t)
+
+	router.Remove("GET", "/activity/:user")
+	RunRequest(router, "GET", "/api/activity/raccoon", 404, "Not Found", t)
+
+	if _, ok := router.staticCache[httpGET]["/static/path/to/hello"]; ok {
+		t.Errorf("Removed path is still cached")
+	}
+	if router.staticCache[httpGET]["/"] == nil {
+		t.Errorf("Index path is not cached")
+	}
+}
+
+func TestStaticCacheFrozen(t *testing.T) {
+	router := MakeRouter()
+	router.GET("/hello", printHello)
+	router.GET("/activity/:user", writeData)
+	router.Freeze()
+	router.EnableStaticCache()
+
+	if router.staticCache[httpGET]["/hello"] == nil {
+		t.Errorf("Frozen path is not cached")
+	}
+	RunRequest(router, "GET", "/hello", 200, "hello", t)
+	RunRequest(router, "GET", "/activity/raccoon", 200, "raccoon--", t)
+}
+
+func TestRouteMetadata(t *testing.T) {
+	router := MakeRouter()
+	router.GET("/", printHello)
+	router.GET("/users/:id", func(w http.ResponseWriter, _ *http.Request, p *ParameterList) {
+		fmt.Fprintf(w, p.Route().Metadata.Summary+" "+p.Get("id"))
+	}).WithSummary("Get a user").WithTags("users")
+	router.DELETE("/users/:id", printHello).WithScopes("admin")
+	router.GET("/scopes", func(w http.ResponseWriter, _ *http.Request, p *ParameterList) {
+		fmt.Fprintf(w, "%v", p.Route().Metadata.Scopes)
+	}).WithMetadata(Metadata{Scopes: []string{"read", "write"}})
+
+	RunRequest(router, "GET", "/users/42", 200, "Get a user 42", t)
+	RunRequest(router, "GET", "/scopes", 200, "[read write]", t)
+
+	routes := router.Routes()
+	if len(routes) != 4 {
+		t.Fatalf("Expected 4 routes, got: %v", len(routes))
+	}
+	if routes[3].Method != "GET" || routes[3].Path != "/users/:id" || !routes[3].Metadata.HasTag("users") {
+		t.Errorf("Unexpected route: %+v", routes[3])
+	}
+
+	router.Freeze()
+	if len(router.Routes()) != 4 {
+		t.Errorf("Frozen router lost routes")
+	}
+	RunRequest(router, "GET", "/users/42", 200, "Get a user 42", t)
+}
+
+func TestRoutePattern(t *testing.T) {
+	printPattern := func(w http.ResponseWriter, _ *http.Request, p *ParameterList) {
+		fmt.Fprintf(w, p.Route().Method+" "+p.Route().Pattern())
+	}
+
+	router := MakeRouter()
+	router.GET("/", printPattern)
+	router.GET("/users/:id", printPattern)
+	router.UsePrefix("/api")
+	router.POST("/users", printPattern)
+
+	RunRequest(router, "GET", "/api", 200, "GET /api", t)
+	RunRequest(router, "GET", "/api/users/42", 200, "GET /api/users/:id", t)
+	RunRequest(router, "POST", "/api/users", 200, "POST /api/users", t)
+
+	req, _ := http.NewRequest("GET", "/api/users/42", nil)
+	req, tracker := TrackRoute(req)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if tracker.Pattern() != "/api/users/:id" || MatchedRoute(req) != tracker.Route() {
+		t.Errorf("Unexpected tracked pattern: %s", tracker.Pattern())
+	}
+
+	req, tracker = TrackRoute(httptest.NewRequest("GET", "/api/none", nil))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if tracker.Route() != nil {
+		t.Errorf("Not found request should not track a route")
+	}
+
+	// not found and not allowed handlers get a nil parameter list
+	var nilParams *ParameterList
+	if nilParams.Route().Pattern() != "" {
+		t.Errorf("Nil route should have an empty pattern")
+	}
+}
+
+func TestErrorHandlers(t *testing.T) {
+	router := MakeRouter()
+	router.HandleErr("GET", "/users/:id", func(w http.ResponseWriter, _ *http.Request, p *ParameterList) error {
+		switch p.Get("id") {
+		case "missing":
+			return NotFoundError("No such user")
+		case "bad":
+			return fmt.Errorf("loading user: %w", ValidationError("Invalid id"))
+		case "broken":
+			return fmt.Errorf("database is down")
+		}
+		fmt.Fprintf(w, "user")
+		return nil
+	})
+	router.GET("/conflict", router.WrapErr(func(http.ResponseWriter, *http.Request, *ParameterList) error {
+		return ConflictError("")
+	}))
+
+	RunRequest(router, "GET", "/users/1", 200, "user", t)
+	RunRequest(router, "GET", "/users/missing", 404, "No such user", t)
+	RunRequest(router, "GET", "/users/bad", 400, "Invalid id", t)
+	RunRequest(router, "GET", "/users/broken", 500, "Internal Server Error", t)
+	RunRequest(router, "GET", "/conflict", 409, "Conflict", t)
+
+	// wrapped handlers can be used where no route is matched
+	notFound := MakeRouter()
+	notFound.GET("/", printHello)
+	notFound.SetNotFoundHandler(notFound.WrapErr(func(http.ResponseWriter, *http.Request, *ParameterList) error {
+		return fmt.Errorf("storage is down")
+	}))
+	RunRequest(notFound, "GET", "/none", 500, "Internal Server Error", t)
+
+	// errors, panics and default pages share the same handler
+	router.GET("/panic", panicHandler)
+	router.SetErrorHandler(func(w http.ResponseWriter, _ *http.Request, err error) {
+		w.WriteHeader(ErrorStatus(err))
+		fmt.Fprintf(w, "custom %d", ErrorStatus(err))
+	})
+	RunRequest(router, "GET", "/users/missing", 404, "custom 404", t)
+	RunRequest(router, "GET", "/none", 404, "custom 404", t)
+	RunRequest(router, "PUT", "/none", 405, "custom 405", t)
+	RunRequest(router, "GET", "/panic", 500, "custom 500", t)
+}
+
+func TestProblemPages(t *testing.T) {
+	router := MakeRouter()
+	router.HandleErr("GET", "/users/:id", func(http.ResponseWriter, *http.Request, *ParameterList) error {
+		return NotFoundError("No such user")
+	})
+	router.HandleErr("GET", "/teapot", func(http.ResponseWriter, *http.Request, *ParameterList) error {
+		return &Problem{Type: "https://example.com/teapot", Title: "Teapot", Status: 418}
+	})
+
+	cases := []struct {
+		path, accept string
+		status       int
+		contentType  string
+		body         string
+	}{
+		{"/none", "", 404, "text/plain; charset=utf-8", "Not Found"},
+		{"/none", "*/*", 404, "text/plain; charset=utf-8", "Not Found"},
+		{"/none", "application/json", 404, "application/problem+json",
+			`{"type":"about:blank","title":"Not Found","status":404,"instance":"/none"}`},
+		{"/users/1", "text/plain;q=0.5, application/problem+json", 404, "application/problem+json",
+			`{"type":"about:blank","title":"Not Found","status":404,"detail":"No such user","instance":"/users/1"}`},
+		{"/users/1", "application/json;q=0.1, text/*", 404, "text/plain; charset=utf-8", "No such user"},
+		{"/teapot", "application/json", 418, "application/problem+json",
+			`{"type":"https://example.com/teapot","title":"Teapot","status":418,"instance":"/teapot"}`},
+	}
+
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", c.path, nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != c.status || recorder.Header().Get("Content-Type") != c.contentType || recorder.Body.String() != c.body {
+			t.Errorf("Unexpected problem for %s (%s): %d %s %s", c.path, c.accept, recorder.Code,
+				recorder.Header().Get("Content-Type"), recorder.Body.String())
+		}
+	}
+}
+
+func TestPanicReport(t *testing.T) {
+	var report *PanicReport
+
+	router := MakeRouter()
+	router.GET("/users/:id", panicHandler)
+	router.GET("/partial", func(w http.ResponseWriter, _ *http.Request, _ *ParameterList) {
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "partial")
+		panic("PANIC")
+	})
+	router.GET("/abort", func(http.ResponseWriter, *http.Request, *ParameterList) {
+		panic(http.ErrAbortHandler)
+	})
+	router.SetPanicHandler(func(w http.ResponseWriter, _ *http.Request, pr *PanicReport) {
+		report = pr
+		w.WriteHeader(500)
+		fmt.Fprintf(w, "panic")
+	})
+
+	req := WithRequestID(httptest.NewRequest("GET", "/users/42", nil), "abc")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != 500 || recorder.Body.String() != "panic" {
+		t.Errorf("Unexpected panic page: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if report.Value != "PANIC" || report.Pattern() != "/users/:id" || report.RequestID != "abc" ||
+		len(report.Stack) == 0 || report.ResponseStarted {
+		t.Errorf("Unexpected panic report: %+v", report)
+	}
+
+	// a started responce can't be fixed so the connection is aborted
+	recorder = httptest.NewRecorder()
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("Expected abort panic, got: %v", err)
+			}
+		}()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/partial", nil))
+	}()
+	if !report.ResponseStarted || recorder.Body.String() != "partial" {
+		t.Errorf("Started responce should not be changed: %s", recorder.Body.String())
+	}
+
+	report = nil
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("Expected abort panic, got: %v", err)
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	}()
+	if report != nil {
+		t.Errorf("Abort panics should not reach the panic handler")
+	}
+}
+
+func TestWriterInterfaces(t *testing.T) {
+	router := MakeRouter()
+	router.GET("/flush", func(w http.ResponseWriter, _ *http.Request, _ *ParameterList) {
+		fmt.Fprintf(w, "data")
+		w.(http.Flusher).Flush()
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/flush", nil))
+	if !recorder.Flushed || recorder.Body.String() != "data" {
+		t.Errorf("Flush was not forwarded")
+	}
+}
+
+func TestLoggers(t *testing.T) {
+	var buf bytes.Buffer
+
+	router := MakeRouter()
+	router.GET("/panic", panicHandler)
+	router.SetLogger(NewJSONLogger(&buf, LevelInfo))
+
+	RunRequest(router, "PUT", "/panic", 405, "Method Not Allowed", t)
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil || entry["level"] != "INFO" || entry["method"] != "PUT" {
+		t.Errorf("Unexpected json log: %s", buf.String())
+	}
+
+	buf.Reset()
+	router.SetLogger(NewStdLogger(log.New(&buf, "", 0), LevelError))
+	RunRequest(router, "PUT", "/panic", 405, "Method Not Allowed", t)
+	if buf.Len() != 0 {
+		t.Errorf("Message below min level was logged: %s", buf.String())
+	}
+	RunRequest(router, "GET", "/panic", 500, "Something went wrong with your request", t)
+	if !strings.HasPrefix(buf.String(), "ERROR Panic while handling request panic=PANIC method=GET path=/panic route=/panic") {
+		t.Errorf("Unexpected std log: %s", buf.String())
+	}
+
+	router.SetLogger(NopLogger{})
+	RunRequest(router, "PUT", "/panic", 405, "Method Not Allowed", t)
+}
@@ -0,0 +1,47 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger forwards messages to a log/slog logger, fields are passed as slog attributes
+// it's built only with Go 1.21 or newer, the rest of the package works with the Go version of go.mod
+type SlogLogger struct {
+	Logger *slog.Logger
+}
+
+// Log implements Logger
+func (sl SlogLogger) Log(level LogLevel, msg string, fields ...interface{}) {
+	var slevel slog.Level
+	switch level {
+	case LevelDebug:
+		slevel = slog.LevelDebug
+	case LevelInfo:
+		slevel = slog.LevelInfo
+	case LevelWarn:
+		slevel = slog.LevelWarn
+	default:
+		slevel = slog.LevelError
+	}
+	sl.Logger.Log(context.Background(), slevel, msg, fields...)
+}
@@ -0,0 +1,80 @@
+/*
+   Copyright 2020 rickycorte
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package router
+
+// add, update or delete (nil route) a static path in the cache if the cache is enabled
+func (r *Router) updateStaticCache(method int, path string, route *Route) {
+	if !r.useStaticCache {
+		return
+	}
+
+	if route == nil {
+		delete(r.staticCache[method], path)
+		return
+	}
+
+	if r.staticCache[method] == nil {
+		r.staticCache[method] = make(map[string]*Route)
+	}
+	r.staticCache[method][path] = route
+}
+
+// collect all the routes without parameters of a tree
+// static node names are full segments (es: "/api") so the path is just the concatenation of the names
+func collectStaticTree(node *pathNode, path string, out map[string]*Route) {
+	for _, container := range node.staticRoutes {
+		for _, child := range container {
+			if child.route != nil {
+				out[path+child.name] = child.route
+			}
+			collectStaticTree(child, path+child.name, out)
+		}
+	}
+}
+
+// collect all the routes without parameters of a frozen tree
+func collectStaticFrozen(ft *frozenTree, node int32, path string, out map[string]*Route) {
+	parent := &ft.nodes[node]
+	for i := parent.firstChild; i < parent.firstChild+parent.childCount; i++ {
+		if ft.nodes[i].route != nil {
+			out[path+ft.name(i)] = ft.nodes[i].route
+		}
+		collectStaticFrozen(ft, i, path+ft.name(i), out)
+	}
+}
+
+// EnableStaticCache adds an exact match map for routes without parameters that is checked before
+// searching the path trees, this speeds up the most common static urls (paths are matched after the prefix is removed)
+// the cache is kept up to date when routes are added or removed
+func (r *Router) EnableStaticCache() {
+	r.useStaticCache = true
+
+	for i := 0; i < httpTotalMethods; i++ {
+		cache := make(map[string]*Route)
+		if r.frozen && r.frozenTrees[i] != nil {
+			collectStaticFrozen(r.frozenTrees[i], 0, "", cache)
+		} else if !r.frozen && r.pathTrees[i] != nil {
+			collectStaticTree(r.pathTrees[i], "", cache)
+		}
+
+		if len(cache) > 0 {
+			r.staticCache[i] = cache
+		} else {
+			r.staticCache[i] = nil
+		}
+	}
+}
@@ -16,6 +16,9 @@
 
 package router
 
+// method names indexed by their mapped int
+var methodNames = [httpTotalMethods]string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
 // convert method from string into a mapped int
 func methodToInt(method string) int {
 	if len(method) < 3 {
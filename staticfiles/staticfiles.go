@@ -17,7 +17,6 @@
 package staticfiles
 
 import (
-	"log"
 	"net/http"
 	"os"
 	"path"
@@ -29,19 +28,24 @@ import (
 // it also allows to redirect system files to another path by setting diffentet prefix and systemPath
 // prefix is set when adding this handler to a router
 func Serve(systemPath string, notFound router.RequestHandler) router.RequestHandler {
+	return ServeWithLogger(systemPath, notFound, router.DefaultLogger())
+}
+
+// ServeWithLogger is like Serve but writes file lookups (debug level) to a custom logger
+func ServeWithLogger(systemPath string, notFound router.RequestHandler, logger router.Logger) router.RequestHandler {
 
 	return func(w http.ResponseWriter, r *http.Request, p *router.ParameterList) {
 
 		target := "/index.html"
 
-		if p != nil {
+		if p != nil && p.Get("*") != "" {
 			target = path.Clean(p.Get("*"))
 		}
 
 		target = systemPath + target
 
 		_, err := os.Stat(target)
-		log.Printf("Seaching: %s\n", target)
+		logger.Log(router.LevelDebug, "Searching static file", "file", target)
 		if os.IsNotExist(err) {
 			notFound(w, r, nil)
 		} else {